@@ -1,13 +1,27 @@
 package commands
 
 import (
-	"time"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
 
 	shell "github.com/ipfs/go-ipfs-api"
 	logging "github.com/ipfs/go-log"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipfs"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ledger"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/resultlog"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
+	"github.com/ipfs-shipyard/gateway-monitor/tasks"
 )
 
 var (
@@ -15,29 +29,36 @@ var (
 	All = []*cli.Command{
 		singleCommand,
 		daemonCommand,
+		payloadCommand,
 	}
 )
 
 // utility functions
-func GetIPFS(cctx *cli.Context) *shell.Shell {
-	sh := new(shell.Shell)
+
+// GetIPFS waits for the IPFS node to be reachable, for up to
+// --ipfs-startup-timeout, and keeps checking on it for as long as cctx lives.
+func GetIPFS(cctx *cli.Context) (task.Node, error) {
+	interval := cctx.Duration("ipfs-health-interval")
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid ipfs health interval %s. expected a positive duration", interval)
+	}
+
+	var sh *shell.Shell
 	if cctx.IsSet("ipfs") {
 		sh = shell.NewShell(cctx.String("ipfs"))
 	} else {
 		sh = shell.NewLocalShell()
 	}
-
-	// Wait for the IPFS daemon to be ready
-	for {
-		_, err := sh.ID()
-		if err == nil {
-			break
-		}
-		log.Info("Waiting for IPFS daemon to be ready")
-		time.Sleep(1 * time.Second)
+	if sh == nil {
+		return nil, fmt.Errorf("no IPFS API address set and none found in IPFS_PATH")
 	}
 
-	return sh
+	node := ipfs.NewCheckedNode(ipfs.NewShellNode(sh))
+	if err := node.WaitUp(cctx.Context, cctx.Duration("ipfs-startup-timeout")); err != nil {
+		return nil, err
+	}
+	go node.Watch(cctx.Context, interval)
+	return node, nil
 }
 
 func GetGW(cctx *cli.Context) string {
@@ -48,11 +69,173 @@ func GetGW(cctx *cli.Context) string {
 	return "https://ipfs.io"
 }
 
-func GetPinningService(cctx *cli.Context) *pinning.Client {
+// pinningServiceConfig is one entry of the --pinning-services-file.
+type pinningServiceConfig struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// GetPinningServices returns the pinning service given by --pinning-service
+// and --pinning-token, followed by any listed in --pinning-services-file.
+func GetPinningServices(cctx *cli.Context) (task.PinningServices, error) {
+	var configs []pinningServiceConfig
 	if cctx.IsSet("pinning-service") && cctx.IsSet("pinning-token") {
-		url := cctx.String("pinning-service")
-		tok := cctx.String("pinning-token")
-		return pinning.NewClient(url, tok)
+		configs = append(configs, pinningServiceConfig{
+			URL:   cctx.String("pinning-service"),
+			Token: cctx.String("pinning-token"),
+		})
 	}
+	if path := cctx.String("pinning-services-file"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pinning services file: %w", err)
+		}
+		var listed []pinningServiceConfig
+		if err := json.Unmarshal(b, &listed); err != nil {
+			return nil, fmt.Errorf("failed to parse pinning services file %s: %w", path, err)
+		}
+		configs = append(configs, listed...)
+	}
+
+	var services task.PinningServices
+	names := make(map[string]bool)
+	for _, c := range configs {
+		name := c.Name
+		if name == "" {
+			// unnamed services are labelled by host
+			u, err := url.Parse(c.URL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pinning service url %q: %w", c.URL, err)
+			}
+			name = u.Host
+		}
+		if names[name] {
+			return nil, fmt.Errorf("pinning service %q is configured more than once", name)
+		}
+		names[name] = true
+		services = append(services, task.NewPinningService(name, c.URL, c.Token))
+	}
+	return services, nil
+}
+
+// ConfigureTasks applies the global flags that tune how tasks run.
+func ConfigureTasks(cctx *cli.Context) error {
+	if cctx.Bool("matrix") {
+		tasks.All = append(tasks.All, tasks.Matrix...)
+	}
+	tasks.IdleReadTimeout = cctx.Duration("idle-timeout")
+	tasks.LegacyHistograms = cctx.Bool("legacy-histograms")
+	tasks.RequestIDHeader = cctx.String("request-id-header")
+	tasks.PinWaitBackoff.Initial = cctx.Duration("pin-poll-interval")
+	tasks.PinWaitBackoff.Max = cctx.Duration("pin-poll-max-interval")
+	if err := tasks.PinWaitBackoff.Validate(); err != nil {
+		return fmt.Errorf("invalid pin polling: %w", err)
+	}
+
+	mode, err := tasks.ParseConnMode(cctx.String("conn-mode"))
+	if err != nil {
+		return err
+	}
+	tasks.DefaultConnMode = mode
+
+	proto, err := tasks.ParseProtocol(cctx.String("protocol"))
+	if err != nil {
+		return err
+	}
+	tasks.DefaultProtocol = proto
+
+	origins, err := tasks.ParseOriginsMode(cctx.String("origins"))
+	if err != nil {
+		return err
+	}
+	tasks.DefaultOrigins = origins
+
+	protocols := make(map[string]tasks.Protocol)
+	for _, tp := range cctx.StringSlice("task-protocol") {
+		parts := strings.SplitN(tp, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid task protocol %q. expected <test>=<protocol>", tp)
+		}
+		proto, err := tasks.ParseProtocol(parts[1])
+		if err != nil {
+			return err
+		}
+		protocols[parts[0]] = proto
+	}
+	if err := tasks.SetTaskProtocols(protocols); err != nil {
+		return err
+	}
+
+	tasks.ProvideBeforeFetch = cctx.Bool("provide")
+	tasks.DiscoverTimeout = cctx.Duration("discover-timeout")
+	if cctx.IsSet("discovery-ipfs") {
+		tasks.DiscoveryNode = ipfs.NewShellNode(shell.NewShell(cctx.String("discovery-ipfs")))
+	}
+
+	if path := cctx.String("histograms-file"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read histograms file: %w", err)
+		}
+		var configs []tasks.HistogramConfig
+		if err := json.Unmarshal(b, &configs); err != nil {
+			return fmt.Errorf("failed to parse histograms file %s: %w", path, err)
+		}
+		if err := tasks.ConfigureHistograms(configs, GetGW(cctx)); err != nil {
+			return fmt.Errorf("invalid histograms file %s: %w", path, err)
+		}
+	}
+
+	if path := cctx.String("results-log"); path != "" {
+		results, err := resultlog.Open(path, cctx.Int64("results-log-max-size"), cctx.Int("results-log-keep"))
+		if err != nil {
+			return err
+		}
+		tasks.Results = results
+	}
+
+	tasks.GCThreshold = cctx.Int64("gc-threshold")
+	l, err := ledger.Open(cctx.String("ledger"))
+	if err != nil {
+		return err
+	}
+	tasks.Ledger = l
 	return nil
 }
+
+// metricsHandler serves the metrics in a format that carries exemplars.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+}
+
+// SetupTracing exports spans to --otlp-endpoint and --trace-file. Defer the
+// returned func to flush them before exiting.
+func SetupTracing(cctx *cli.Context) (func(), error) {
+	shutdown, err := tracing.Setup(cctx.Context, tracing.Config{
+		OTLPEndpoint: cctx.String("otlp-endpoint"),
+		File:         cctx.String("trace-file"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Warnw("failed to flush traces", "err", err)
+		}
+	}, nil
+}
+
+// CleanUpLeftovers cleans up whatever runs that didn't finish, before the
+// monitor last stopped, left behind.
+func CleanUpLeftovers(cctx *cli.Context, node task.Node, ps task.PinningServices) {
+	for _, cleanup := range []task.Task{tasks.NewLedgerCleanup(""), tasks.NewRemotePinCleanup("")} {
+		if err := cleanup.Run(cctx.Context, node, ps, ""); err != nil {
+			log.Warnw("failed to clean up after earlier runs", "task", cleanup.Name(), "err", err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/pinningtest"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// pinningServices runs GetPinningServices with the given command line.
+func pinningServices(args ...string) (task.PinningServices, error) {
+	var services task.PinningServices
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "pinning-service"},
+			&cli.StringFlag{Name: "pinning-token"},
+			&cli.StringFlag{Name: "pinning-services-file"},
+		},
+		Action: func(cctx *cli.Context) (err error) {
+			services, err = GetPinningServices(cctx)
+			return err
+		},
+	}
+	err := app.Run(append([]string{"gateway-monitor"}, args...))
+	return services, err
+}
+
+func writeServicesFile(t *testing.T, services string) string {
+	path := filepath.Join(t.TempDir(), "services.json")
+	if err := os.WriteFile(path, []byte(services), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetPinningServices(t *testing.T) {
+	flagged := pinningtest.NewServer("flag-token")
+	defer flagged.Close()
+	listed := pinningtest.NewServer("file-token")
+	defer listed.Close()
+	revoked := pinningtest.NewServer("new-token")
+	defer revoked.Close()
+
+	path := writeServicesFile(t, fmt.Sprintf(`[
+		{"name": "listed", "url": %q, "token": "file-token"},
+		{"name": "revoked", "url": %q, "token": "old-token"}
+	]`, listed.URL, revoked.URL))
+	services, err := pinningServices(
+		"--pinning-service", flagged.URL,
+		"--pinning-token", "flag-token",
+		"--pinning-services-file", path,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(flagged.URL)
+	names := []string{u.Host, "listed", "revoked"}
+	if len(services) != len(names) {
+		t.Fatalf("got %d services, expected %d", len(services), len(names))
+	}
+	c, _ := cid.Decode("bafkqaaa")
+	for i, ps := range services {
+		if ps.Name != names[i] {
+			t.Errorf("service %d is named %q, expected %q", i, ps.Name, names[i])
+		}
+		_, err := ps.Add(context.Background(), c)
+		if ps.Name == "revoked" {
+			if err == nil {
+				t.Error("expected the service to reject a stale token")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", ps.Name, err)
+		}
+	}
+	for _, srv := range []*pinningtest.Server{flagged, listed} {
+		if pins := srv.Pins(); len(pins) != 1 {
+			t.Errorf("%s has %d pins, expected 1", srv.URL, len(pins))
+		}
+	}
+}
+
+func TestGetPinningServicesDuplicate(t *testing.T) {
+	path := writeServicesFile(t, `[
+		{"name": "same", "url": "https://one.example.com", "token": "a"},
+		{"name": "same", "url": "https://two.example.com", "token": "b"}
+	]`)
+	if _, err := pinningServices("--pinning-services-file", path); err == nil {
+		t.Error("expected services with the same name to be rejected")
+	}
+}
+
+func TestGetIPFSHealthInterval(t *testing.T) {
+	for _, interval := range []string{"0s", "-1s"} {
+		app := &cli.App{
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "ipfs"},
+				&cli.DurationFlag{Name: "ipfs-startup-timeout"},
+				&cli.DurationFlag{Name: "ipfs-health-interval"},
+			},
+			Action: func(cctx *cli.Context) error {
+				_, err := GetIPFS(cctx)
+				return err
+			},
+		}
+		if err := app.Run([]string{"gateway-monitor", "--ipfs-health-interval", interval}); err == nil {
+			t.Errorf("expected a health interval of %s to be rejected", interval)
+		}
+	}
+}
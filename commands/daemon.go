@@ -4,7 +4,6 @@ import (
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
 
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/engine"
@@ -26,9 +25,31 @@ var daemonCommand = &cli.Command{
 	Name:  "daemon",
 	Usage: "run commands on schedule",
 	Action: func(cctx *cli.Context) error {
-		ipfs := GetIPFS(cctx)
-		ps := GetPinningService(cctx)
+		if err := ConfigureTasks(cctx); err != nil {
+			return err
+		}
+		stopTracing, err := SetupTracing(cctx)
+		if err != nil {
+			return err
+		}
+		defer stopTracing()
+		// serve metrics while waiting for IPFS, so ipfs_up can be seen
+		http.Handle("/metrics", metricsHandler())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- http.ListenAndServe(":2112", nil)
+		}()
+
+		ipfs, err := GetIPFS(cctx)
+		if err != nil {
+			return err
+		}
+		ps, err := GetPinningServices(cctx)
+		if err != nil {
+			return err
+		}
 		gw := GetGW(cctx)
+		CleanUpLeftovers(cctx, ipfs, ps)
 		eng := engine.New(ipfs, ps, gw, tasks.All...)
 		go func() {
 			errCh := eng.Start(cctx.Context)
@@ -39,7 +60,6 @@ var daemonCommand = &cli.Command{
 				}
 			}
 		}()
-		http.Handle("/metrics", promhttp.Handler())
-		return http.ListenAndServe(":2112", nil)
+		return <-srvErr
 	},
 }
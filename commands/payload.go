@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/tasks"
+)
+
+var payloadCommand = &cli.Command{
+	Name:  "payload",
+	Usage: "regenerate the content of a failed run from its seed and add it to IPFS again",
+	Description: "Tasks log the seed and size of the random content they publish, and include them\n" +
+		"in the error when a run fails. Given the same seed, size and layout, this adds the\n" +
+		"exact same content to the IPFS node and prints its CID.",
+	Action: func(cctx *cli.Context) error {
+		r := tasks.PayloadReader(cctx.Int64("seed"), cctx.Int("size"))
+
+		if out := cctx.String("output"); out != "" {
+			var w io.Writer = os.Stdout
+			if out != "-" {
+				f, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			_, err := io.Copy(w, r)
+			return err
+		}
+
+		node, err := GetIPFS(cctx)
+		if err != nil {
+			return err
+		}
+		layout := task.Layout{
+			CidVersion: cctx.Int("cid-version"),
+			Hash:       cctx.String("hash"),
+			Chunker:    cctx.String("chunker"),
+			RawLeaves:  cctx.Bool("raw-leaves"),
+		}
+		c, err := node.Add(cctx.Context, r, layout)
+		if err != nil {
+			return fmt.Errorf("failed to add payload to IPFS: %w", err)
+		}
+		fmt.Println(c)
+		return nil
+	},
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:     "seed",
+			Usage:    "seed the payload was generated from",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:     "size",
+			Usage:    "size of the payload in bytes",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:  "cid-version",
+			Usage: "CID version the task published with. Leave the layout flags unset for the node's defaults",
+		},
+		&cli.StringFlag{
+			Name:  "hash",
+			Usage: "hash function the task published with, such as sha2-256 or blake3",
+		},
+		&cli.StringFlag{
+			Name:  "chunker",
+			Usage: "chunker the task published with, such as size-262144, rabin or buzhash",
+		},
+		&cli.BoolFlag{
+			Name:  "raw-leaves",
+			Usage: "whether the task published with raw leaves",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "write the payload to this file, or - for stdout, instead of adding it to IPFS",
+		},
+	},
+}
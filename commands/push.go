@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/remotewrite"
+)
+
+const pushTimeout = 30 * time.Second
+
+var pushFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "pushgateway",
+		Usage: "Pushgateway to push the final metrics to before exiting",
+		EnvVars: []string{
+			"GATEWAY_MONITOR_PUSHGATEWAY",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "remote-write",
+		Usage: "Prometheus remote-write endpoint to write the final metrics to before exiting",
+		EnvVars: []string{
+			"GATEWAY_MONITOR_REMOTE_WRITE",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "push-job",
+		Usage: "job label of pushed metrics",
+		Value: "gateway-monitor",
+		EnvVars: []string{
+			"GATEWAY_MONITOR_PUSH_JOB",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "push-instance",
+		Usage: "instance label of pushed metrics. defaults to the hostname",
+		EnvVars: []string{
+			"GATEWAY_MONITOR_PUSH_INSTANCE",
+		},
+	},
+}
+
+// PushMetrics sends every registered metric to the Pushgateway and
+// remote-write endpoint, if set, grouped by --push-job and --push-instance.
+// Scraping misses the results of runs that exit right after.
+func PushMetrics(cctx *cli.Context) error {
+	if !cctx.IsSet("pushgateway") && !cctx.IsSet("remote-write") {
+		return nil
+	}
+	job := cctx.String("push-job")
+	instance := cctx.String("push-instance")
+	if instance == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname for the instance label: %w", err)
+		}
+		instance = host
+	}
+
+	// the run's context may be done by now, but the results still have to go
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+
+	var failed error
+	if url := cctx.String("pushgateway"); url != "" {
+		err := push.New(url, job).
+			Grouping("instance", instance).
+			Gatherer(prometheus.DefaultGatherer).
+			PushContext(ctx)
+		if err != nil {
+			log.Errorw("failed to push metrics to pushgateway", "url", url, "err", err)
+			failed = fmt.Errorf("failed to push metrics to %s: %w", url, err)
+		} else {
+			log.Infow("pushed metrics to pushgateway", "url", url, "job", job, "instance", instance)
+		}
+	}
+	if url := cctx.String("remote-write"); url != "" {
+		err := remotewrite.Push(ctx, http.DefaultClient, url, prometheus.DefaultGatherer, map[string]string{
+			"job":      job,
+			"instance": instance,
+		})
+		if err != nil {
+			log.Errorw("failed to remote write metrics", "url", url, "err", err)
+			failed = fmt.Errorf("failed to remote write metrics to %s: %w", url, err)
+		} else {
+			log.Infow("remote wrote metrics", "url", url, "job", job, "instance", instance)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/pushtest"
+)
+
+// pushMetrics runs PushMetrics with the given command line.
+func pushMetrics(args ...string) error {
+	app := &cli.App{
+		Flags:  pushFlags,
+		Action: PushMetrics,
+	}
+	return app.Run(append([]string{"gateway-monitor"}, args...))
+}
+
+// registerRuns registers a counter for the pushes to carry, for the length of
+// the test.
+func registerRuns(t *testing.T) {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "gatewaymonitor_push_test_runs_count"})
+	c.Add(3)
+	prometheus.MustRegister(c)
+	t.Cleanup(func() { prometheus.Unregister(c) })
+}
+
+func TestPushMetrics(t *testing.T) {
+	registerRuns(t)
+	srv := pushtest.NewServer()
+	defer srv.Close()
+
+	err := pushMetrics(
+		"--pushgateway", srv.URL,
+		"--remote-write", srv.WriteURL(),
+		"--push-job", "ci",
+		"--push-instance", "run-1",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushes := srv.Pushes()
+	if len(pushes) != 1 {
+		t.Fatalf("got %d pushes, expected 1", len(pushes))
+	}
+	if g := pushes[0].Grouping; g["job"] != "ci" || g["instance"] != "run-1" {
+		t.Errorf("pushed grouped by %v, expected job ci and instance run-1", g)
+	}
+	var pushed bool
+	for _, mf := range pushes[0].Families {
+		if mf.GetName() == "gatewaymonitor_push_test_runs_count" {
+			pushed = mf.Metric[0].GetCounter().GetValue() == 3
+		}
+	}
+	if !pushed {
+		t.Error("the pushgateway didn't get the counter")
+	}
+
+	var written bool
+	for _, s := range srv.Series() {
+		if s.Labels["__name__"] != "gatewaymonitor_push_test_runs_count" {
+			continue
+		}
+		written = true
+		if s.Value != 3 || s.Labels["job"] != "ci" || s.Labels["instance"] != "run-1" {
+			t.Errorf("remote wrote %v = %v, expected 3 under job ci and instance run-1", s.Labels, s.Value)
+		}
+	}
+	if !written {
+		t.Error("the remote-write endpoint didn't get the counter")
+	}
+}
+
+func TestPushMetricsFailure(t *testing.T) {
+	registerRuns(t)
+	srv := pushtest.NewServer()
+	defer srv.Close()
+	srv.FailRequests(1, http.StatusInternalServerError)
+
+	// the first push fails, but the remote write still has to happen
+	err := pushMetrics("--pushgateway", srv.URL, "--remote-write", srv.WriteURL(), "--push-instance", "run-1")
+	if err == nil {
+		t.Error("expected the failed push to be reported")
+	}
+	if len(srv.Series()) == 0 {
+		t.Error("expected the remote write to go ahead")
+	}
+}
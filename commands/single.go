@@ -5,7 +5,6 @@ import (
 	"os"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
 
 	logging "github.com/ipfs/go-log"
@@ -24,17 +23,21 @@ var singleCommand = &cli.Command{
 			logging.SetAllLoggers(logging.LevelInfo)
 		}
 
-		ipfs := GetIPFS(cctx)
-		ps := GetPinningService(cctx)
-		gw := GetGW(cctx)
-
+		if err := ConfigureTasks(cctx); err != nil {
+			return err
+		}
+		stopTracing, err := SetupTracing(cctx)
+		if err != nil {
+			return err
+		}
+		defer stopTracing()
 		for _, t := range tasks.All {
 			for _, col := range t.Registration().Collectors {
 				prometheus.Register(col)
 			}
 		}
 
-		http.Handle("/metrics", promhttp.Handler())
+		http.Handle("/metrics", metricsHandler())
 
 		go func() {
 			http.ListenAndServe(":2112", nil)
@@ -42,6 +45,17 @@ var singleCommand = &cli.Command{
 
 		log.Info("Prometheus metrics listener running at http://0.0.0.0:2112/metrics")
 
+		ipfs, err := GetIPFS(cctx)
+		if err != nil {
+			return err
+		}
+		ps, err := GetPinningServices(cctx)
+		if err != nil {
+			return err
+		}
+		gw := GetGW(cctx)
+		CleanUpLeftovers(cctx, ipfs, ps)
+
 		eng := engine.NewSingle(ipfs, ps, gw)
 
 		if cctx.IsSet("loop") {
@@ -58,13 +72,25 @@ var singleCommand = &cli.Command{
 				eng.AddTask(t)
 			}
 			eng.AddTask(eng.TerminalTask())
-			return <-eng.Start(cctx.Context)
+			// the channel closes once the terminal task ran, after every
+			// other task, so the metrics are only pushed when all are done
+			var failed error
+			for err := range eng.Start(cctx.Context) {
+				log.Error(err)
+				if failed == nil {
+					failed = err
+				}
+			}
+			if err := PushMetrics(cctx); failed == nil {
+				failed = err
+			}
+			return failed
 		}
 	},
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:  "loop",
 			Usage: "loop forever, running each test one after another, ignoring the schedule",
 		},
-	},
+	}, pushFlags...),
 }
@@ -3,16 +3,19 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/ipfs-shipyard/gateway-monitor/commands"
+	"github.com/ipfs-shipyard/gateway-monitor/tasks"
 )
 
 func main() {
 	app := &cli.App{
 		Name:     "gateway-monitor",
 		Usage:    "monitor IPFS gateway performance",
+		Version:  tasks.Version(),
 		Commands: commands.All,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -22,6 +25,75 @@ func main() {
 					"GATEWAY_MONITOR_IPFS",
 				},
 			},
+			&cli.DurationFlag{
+				Name:  "ipfs-startup-timeout",
+				Usage: "give up if the IPFS node isn't reachable within this long of starting (0 waits forever)",
+				Value: 2 * time.Minute,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_IPFS_STARTUP_TIMEOUT",
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "ipfs-health-interval",
+				Usage: "how often to check the IPFS node is still reachable",
+				Value: 30 * time.Second,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_IPFS_HEALTH_INTERVAL",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "ledger",
+				Usage: "file recording the pins and keys tasks create, so they are cleaned up even after a crash",
+				Value: "gateway-monitor-ledger.json",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_LEDGER",
+				},
+			},
+			&cli.Int64Flag{
+				Name:  "gc-threshold",
+				Usage: "only garbage collect the IPFS node once tasks have unpinned this many bytes",
+				Value: 256 << 20,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_GC_THRESHOLD",
+				},
+			},
+			&cli.BoolFlag{
+				Name:  "matrix",
+				Usage: "also run the random local bench for every DAG layout, and the IPNS bench for every key type and record lifetime, each once an hour",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_MATRIX",
+				},
+			},
+			&cli.BoolFlag{
+				Name:  "provide",
+				Usage: "provide generated content before fetching it from the gateway, and wait for it to be discoverable from --discovery-ipfs if set",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_PROVIDE",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "discovery-ipfs",
+				Usage: "IPFS api Multiaddr of a node other than the local one to run findprovs on when checking content is discoverable. Without it, --provide only provides content and gatewaymonitor_task_common_discoverable_seconds isn't recorded",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_DISCOVERY_IPFS",
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "discover-timeout",
+				Usage: "how long to wait for content to be discoverable before fetching it anyway. capped at half of the time left for the run",
+				Value: 2 * time.Minute,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_DISCOVER_TIMEOUT",
+				},
+			},
+			&cli.BoolFlag{
+				Name:  "legacy-histograms",
+				Usage: "keep recording the per-task gatewaymonitor_task_<test>_latency_seconds and _fetch_seconds histograms alongside gatewaymonitor_probe_*",
+				Value: true,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_LEGACY_HISTOGRAMS",
+				},
+			},
 			&cli.StringFlag{
 				Name: "pinning-service",
 				Aliases: []string{
@@ -42,6 +114,120 @@ func main() {
 					"GATEWAY_MONITOR_PINNING_SERVICE_TOKEN",
 				},
 			},
+			&cli.StringFlag{
+				Name:  "pinning-services-file",
+				Usage: "JSON file listing more pinning services to compare, as [{\"name\", \"url\", \"token\"}]",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_PINNING_SERVICES_FILE",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "request-id-header",
+				Usage: "header to send a unique ID for every request to the gateway in. empty to send none",
+				Value: "X-Request-Id",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_REQUEST_ID_HEADER",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "results-log",
+				Usage: "file to append the result of every probe to, one JSON object per line. - for stdout",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_RESULTS_LOG",
+				},
+			},
+			&cli.Int64Flag{
+				Name:  "results-log-max-size",
+				Usage: "bytes the results log may grow to before it is rotated",
+				Value: 100 << 20,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_RESULTS_LOG_MAX_SIZE",
+				},
+			},
+			&cli.IntFlag{
+				Name:  "results-log-keep",
+				Usage: "rotated results logs to keep",
+				Value: 5,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_RESULTS_LOG_KEEP",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "otlp-endpoint",
+				Usage: "OTLP/HTTP collector to export traces of task runs to, such as http://localhost:4318",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_OTLP_ENDPOINT",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "trace-file",
+				Usage: "file to append traces of task runs to, one JSON object per span",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_TRACE_FILE",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "histograms-file",
+				Usage: "JSON file replacing the buckets of task and probe histograms, or turning them into summaries, as [{\"test\", \"histogram\", \"gateway\", \"type\", ...}]",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_HISTOGRAMS_FILE",
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "pin-poll-interval",
+				Usage: "initial delay between pin status polls. doubles after every poll",
+				Value: 5 * time.Second,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_PIN_POLL_INTERVAL",
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "pin-poll-max-interval",
+				Usage: "maximum delay between pin status polls",
+				Value: time.Minute,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_PIN_POLL_MAX_INTERVAL",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "origins",
+				Usage: "whether pin requests pass the local node's addresses as origins: off, on, or alternate between the two on every run",
+				Value: "alternate",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_ORIGINS",
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "idle-timeout",
+				Usage: "abort a download as stalled when no data arrives for this long",
+				Value: time.Minute,
+				EnvVars: []string{
+					"GATEWAY_MONITOR_IDLE_TIMEOUT",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "conn-mode",
+				Usage: "connection mode for tasks that don't set their own: cold, warm or paired",
+				Value: "warm",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_CONN_MODE",
+				},
+			},
+			&cli.StringFlag{
+				Name:  "protocol",
+				Usage: "HTTP version for tasks that don't set their own: auto, http1, http2 or http3. http2 and http3 ignore HTTP_PROXY",
+				Value: "auto",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_PROTOCOL",
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:  "task-protocol",
+				Usage: "HTTP version for one task, as <test>=<protocol>, such as known_good=http3. can be repeated",
+				EnvVars: []string{
+					"GATEWAY_MONITOR_TASK_PROTOCOL",
+				},
+			},
 		},
 		EnableBashCompletion: true,
 	}
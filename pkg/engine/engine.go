@@ -8,30 +8,43 @@ import (
 	"github.com/robfig/cron/v3"
 
 	logging "github.com/ipfs/go-log"
-
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/queue"
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
 )
 
 var log = logging.Logger("engine")
 
+var task_outcomes = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "gatewaymonitor",
+		Subsystem: "engine",
+		Name:      "task_outcome_count",
+		Help:      "task runs by outcome: ok, error, or node_unavailable when skipped because the IPFS node is down",
+	},
+	[]string{"test", "outcome"})
+
+func init() {
+	prometheus.Register(task_outcomes)
+}
+
 type Engine struct {
 	c    *cron.Cron
 	q    *queue.TaskQueue
-	sh   *shell.Shell
-	ps   *pinning.Client
+	node task.Node
+	ps   task.PinningServices
 	gw   string
 	done chan bool
 }
 
 // Create an engine with Cron and Prometheus setup
-func New(sh *shell.Shell, ps *pinning.Client, gw string, tsks ...task.Task) *Engine {
+func New(node task.Node, ps task.PinningServices, gw string, tsks ...task.Task) *Engine {
 	q := queue.New()
 	c := cron.New()
-	return NewWithQueueAndCron(q, c, sh, ps, gw, tsks...)
+	return NewWithQueueAndCron(q, c, node, ps, gw, tsks...)
 }
 
 // Create an engine passing a queue and cron instance
@@ -39,11 +52,11 @@ func New(sh *shell.Shell, ps *pinning.Client, gw string, tsks ...task.Task) *Eng
 // subscribe to the same queue. In that case, you would instantiate one engine with tasks
 // so they are registered once. Then, any subsequent engines with which the queue is shared
 // will run over the same tasks in parallel.
-func NewWithQueueAndCron(q *queue.TaskQueue, c *cron.Cron, sh *shell.Shell, ps *pinning.Client, gw string, tsks ...task.Task) *Engine {
+func NewWithQueueAndCron(q *queue.TaskQueue, c *cron.Cron, node task.Node, ps task.PinningServices, gw string, tsks ...task.Task) *Engine {
 	eng := Engine{
 		c:    c,
 		q:    q,
-		sh:   sh,
+		node: node,
 		ps:   ps,
 		gw:   gw,
 		done: make(chan bool),
@@ -61,11 +74,11 @@ func NewWithQueueAndCron(q *queue.TaskQueue, c *cron.Cron, sh *shell.Shell, ps *
 }
 
 // Create an engine without Cron and prometheus.
-func NewSingle(sh *shell.Shell, ps *pinning.Client, gw string) *Engine {
+func NewSingle(node task.Node, ps task.PinningServices, gw string) *Engine {
 	return &Engine{
 		c:    cron.New(),
 		q:    queue.New(),
-		sh:   sh,
+		node: node,
 		ps:   ps,
 		gw:   gw,
 		done: make(chan bool, 1),
@@ -81,13 +94,13 @@ func (e *Engine) Start(ctx context.Context) chan error {
 		for {
 			select {
 			case t := <-tch:
-				c, cancel := context.WithTimeout(ctx, 10*time.Minute)
-				defer cancel()
-				log.Infof("Starting task %w", t)
-				if err := t.Run(c, e.sh, e.ps, e.gw); err != nil {
+				if t.Registration().NeedsNode && !e.nodeUp() {
+					log.Warnf("Skipping task %s, IPFS node is unavailable", t.Name())
+					task_outcomes.WithLabelValues(t.Name(), "node_unavailable").Inc()
+					continue
+				}
+				if err := e.run(ctx, t); err != nil {
 					errCh <- err
-				} else {
-					log.Infof("Finished task %w", t)
 				}
 			case <-e.done:
 				return
@@ -98,6 +111,40 @@ func (e *Engine) Start(ctx context.Context) chan error {
 	return errCh
 }
 
+// run runs t under a new root span, which every span t creates descends from.
+func (e *Engine) run(ctx context.Context, t task.Task) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	ctx, span := tracing.Tracer.Start(ctx, t.Name(),
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("test", t.Name()),
+			attribute.String("gateway", e.gw),
+		))
+	defer tracing.End(span, &err)
+	l := tracing.Logger(ctx, &log.SugaredLogger)
+
+	l.Infof("Starting task %s", t.Name())
+	if err := t.Run(ctx, e.node, e.ps, e.gw); err != nil {
+		task_outcomes.WithLabelValues(t.Name(), "error").Inc()
+		span.SetAttributes(attribute.String("outcome", "error"))
+		return err
+	}
+	task_outcomes.WithLabelValues(t.Name(), "ok").Inc()
+	span.SetAttributes(attribute.String("outcome", "ok"))
+	l.Infof("Finished task %s", t.Name())
+	return nil
+}
+
+// nodeUp reports whether the IPFS node is healthy. Nodes that don't report
+// their health are assumed to be.
+func (e *Engine) nodeUp() bool {
+	if h, ok := e.node.(task.HealthReporter); ok {
+		return h.Up()
+	}
+	return true
+}
+
 func (e *Engine) Stop() {
 	e.done <- true
 }
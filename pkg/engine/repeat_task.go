@@ -4,8 +4,6 @@ import (
 	"context"
 
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -35,15 +33,15 @@ func (t *RepeatTask) Name() string {
 	return "repeat_forever"
 }
 
-func (t *RepeatTask) LatencyHist() *prometheus.HistogramVec {
+func (t *RepeatTask) LatencyHist() prometheus.ObserverVec {
 	return nil
 }
 
-func (t *RepeatTask) FetchHist() *prometheus.HistogramVec {
+func (t *RepeatTask) FetchHist() prometheus.ObserverVec {
 	return nil
 }
 
-func (t *RepeatTask) Run(context.Context, *shell.Shell, *pinning.Client, string) error {
+func (t *RepeatTask) Run(context.Context, task.Node, task.PinningServices, string) error {
 	if t.until() {
 		log.Info("Loop finished")
 		t.engine.AddTask(t.engine.TerminalTask())
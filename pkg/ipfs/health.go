@@ -0,0 +1,108 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	logging "github.com/ipfs/go-log"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+var log = logging.Logger("ipfs")
+
+var ipfs_up = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "gatewaymonitor",
+		Subsystem: "ipfs",
+		Name:      "up",
+		Help:      "whether the local IPFS node answered its last health check",
+	})
+
+func init() {
+	prometheus.Register(ipfs_up)
+}
+
+// healthCheckTimeout bounds a single health check, so a node that accepts
+// connections but never answers is still reported down.
+const healthCheckTimeout = 10 * time.Second
+
+// CheckedNode is a task.Node whose health is checked in the background. It
+// implements task.HealthReporter, so the engine can skip tasks that need the
+// node while it is down.
+type CheckedNode struct {
+	task.Node
+	up int32
+}
+
+func NewCheckedNode(node task.Node) *CheckedNode {
+	return &CheckedNode{Node: node}
+}
+
+// Up reports whether the node answered its last health check.
+func (n *CheckedNode) Up() bool {
+	return atomic.LoadInt32(&n.up) == 1
+}
+
+// check asks the node for its ID, recording whether it answered.
+func (n *CheckedNode) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	_, err := n.ID(ctx)
+	if err != nil {
+		if atomic.SwapInt32(&n.up, 0) == 1 {
+			log.Errorw("IPFS node went down", "err", err)
+		}
+		ipfs_up.Set(0)
+		return err
+	}
+	if atomic.SwapInt32(&n.up, 1) == 0 {
+		log.Info("IPFS node is up")
+	}
+	ipfs_up.Set(1)
+	return nil
+}
+
+// WaitUp waits for the node to come up, checking once a second. It gives up
+// after timeout, unless timeout is zero.
+func (n *CheckedNode) WaitUp(ctx context.Context, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		err := n.check(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Infow("Waiting for IPFS daemon to be ready", "err", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("IPFS daemon was not ready within %s: %w", timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Watch checks the node every interval until ctx is done. The interval must be
+// positive.
+func (n *CheckedNode) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.check(ctx)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/engine"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// flakyNode fails its health checks while it is down.
+type flakyNode struct {
+	*MemoryNode
+	down atomic.Bool
+}
+
+func (n *flakyNode) ID(ctx context.Context) (task.NodeID, error) {
+	if n.down.Load() {
+		return task.NodeID{}, errors.New("connection refused")
+	}
+	return n.MemoryNode.ID(ctx)
+}
+
+// nodeTask needs the node, and counts its runs.
+type nodeTask struct {
+	runs atomic.Int32
+}
+
+func (t *nodeTask) Name() string                        { return "health_test" }
+func (t *nodeTask) LatencyHist() prometheus.ObserverVec { return nil }
+func (t *nodeTask) FetchHist() prometheus.ObserverVec   { return nil }
+func (t *nodeTask) Registration() *task.Registration    { return &task.Registration{NeedsNode: true} }
+
+func (t *nodeTask) Run(context.Context, task.Node, task.PinningServices, string) error {
+	t.runs.Add(1)
+	return nil
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// outcomes returns how often the engine counted test with outcome.
+func outcomes(t *testing.T, test string, outcome string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "gatewaymonitor_engine_task_outcome_count" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["test"] == test && labels["outcome"] == outcome {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// runOnce runs tsk on a single run engine, returning once it is done.
+func runOnce(node task.Node, tsk task.Task) {
+	eng := engine.NewSingle(node, nil, "")
+	eng.AddTask(tsk)
+	eng.AddTask(eng.TerminalTask())
+	for range eng.Start(context.Background()) {
+	}
+}
+
+func TestWaitUp(t *testing.T) {
+	node := &flakyNode{MemoryNode: NewMemoryNode()}
+	node.down.Store(true)
+	checked := NewCheckedNode(node)
+
+	if err := checked.WaitUp(context.Background(), 50*time.Millisecond); err == nil {
+		t.Fatal("expected waiting on a node that stays down to time out")
+	}
+	if checked.Up() {
+		t.Error("node that never answered is reported up")
+	}
+	if up := testutil.ToFloat64(ipfs_up); up != 0 {
+		t.Errorf("ipfs_up is %v, expected 0", up)
+	}
+
+	time.AfterFunc(100*time.Millisecond, func() { node.down.Store(false) })
+	if err := checked.WaitUp(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !checked.Up() {
+		t.Error("node that came up is reported down")
+	}
+	if up := testutil.ToFloat64(ipfs_up); up != 1 {
+		t.Errorf("ipfs_up is %v, expected 1", up)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	node := &flakyNode{MemoryNode: NewMemoryNode()}
+	checked := NewCheckedNode(node)
+	if err := checked.WaitUp(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checked.Watch(ctx, 10*time.Millisecond)
+	tsk := &nodeTask{}
+
+	node.down.Store(true)
+	waitFor(t, "the node to be reported down", func() bool { return !checked.Up() })
+	if up := testutil.ToFloat64(ipfs_up); up != 0 {
+		t.Errorf("ipfs_up is %v while the node is down, expected 0", up)
+	}
+	skipped := outcomes(t, tsk.Name(), "node_unavailable")
+	runOnce(checked, tsk)
+	if n := tsk.runs.Load(); n != 0 {
+		t.Errorf("task ran %d times while the node was down", n)
+	}
+	if n := outcomes(t, tsk.Name(), "node_unavailable") - skipped; n != 1 {
+		t.Errorf("counted %v runs as node_unavailable, expected 1", n)
+	}
+
+	node.down.Store(false)
+	waitFor(t, "the node to be reported up again", checked.Up)
+	if up := testutil.ToFloat64(ipfs_up); up != 1 {
+		t.Errorf("ipfs_up is %v after the node recovered, expected 1", up)
+	}
+	runOnce(checked, tsk)
+	if n := tsk.runs.Load(); n != 1 {
+		t.Errorf("task ran %d times after the node recovered, expected once", n)
+	}
+}
@@ -0,0 +1,362 @@
+package ipfs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipnsrecord"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// MemoryNode is a task.Node that keeps everything in memory. Content gets a
+// CID shaped after the layout it was added with, and nothing is announced to
+// any network: the node is only ever a provider to itself. Use Gateway to
+// serve the content back over HTTP.
+type MemoryNode struct {
+	mu        sync.Mutex
+	blocks    map[string][]byte
+	pins      map[string]bool
+	keys      map[string]string // name -> key id
+	provided  map[string]bool
+	names     map[string]string         // key id -> path
+	privKeys  map[string]crypto.PrivKey // key id -> key
+	records   map[string][]byte         // key id -> signed IPNS record
+	connected []string
+}
+
+func NewMemoryNode() *MemoryNode {
+	return &MemoryNode{
+		blocks:   make(map[string][]byte),
+		pins:     make(map[string]bool),
+		keys:     map[string]string{"self": memoryPeerID},
+		names:    make(map[string]string),
+		privKeys: make(map[string]crypto.PrivKey),
+		records:  make(map[string][]byte),
+		provided: make(map[string]bool),
+	}
+}
+
+const memoryPeerID = "12D3KooWQYV9dGMFoRzNStwpXztXaBUjtPqi6aU76ZgUriHhKust"
+
+func (n *MemoryNode) ID(ctx context.Context) (task.NodeID, error) {
+	return task.NodeID{
+		ID: memoryPeerID,
+		Addresses: []string{
+			"/ip4/127.0.0.1/tcp/4001/p2p/" + memoryPeerID,
+			"/ip4/192.0.2.1/tcp/4001/p2p/" + memoryPeerID,
+		},
+	}, nil
+}
+
+func (n *MemoryNode) Add(ctx context.Context, r io.Reader, layout task.Layout) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	c, err := layoutCID(b, layout)
+	if err != nil {
+		return "", err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blocks[c] = b
+	n.pins[c] = true
+	return c, nil
+}
+
+// hashes the go-multihash we build with doesn't know by name
+var extraHashes = map[string]uint64{
+	"blake3": 0x1e,
+}
+
+// layoutCID makes up a CID for b with the version, codec and hash function the
+// layout asks for. It isn't the CID a real node would give the DAG, but it is
+// unique to the content and has the right shape.
+func layoutCID(b []byte, layout task.Layout) (string, error) {
+	name := layout.Hash
+	if name == "" {
+		name = "sha2-256"
+	}
+	code, ok := multihash.Names[name]
+	if !ok {
+		if code, ok = extraHashes[name]; !ok {
+			return "", fmt.Errorf("unknown hash function %q", name)
+		}
+	}
+	mh, err := multihash.Sum(b, code, -1)
+	if err != nil {
+		// not every hash function is linked in, so stand in with a sha2-256
+		// digest. multihash.Encode refuses codes it doesn't know.
+		digest := sha256.Sum256(b)
+		mh = binary.AppendUvarint(nil, code)
+		mh = binary.AppendUvarint(mh, uint64(len(digest)))
+		mh = append(mh, digest[:]...)
+	}
+
+	switch {
+	case layout.IsDefault():
+		return cid.NewCidV1(cid.Raw, mh).String(), nil
+	case layout.CidVersion == 0:
+		if code != multihash.SHA2_256 || layout.RawLeaves {
+			return "", fmt.Errorf("CIDv0 only supports sha2-256 without raw leaves")
+		}
+		return cid.NewCidV0(mh).String(), nil
+	case layout.RawLeaves:
+		return cid.NewCidV1(cid.Raw, mh).String(), nil
+	}
+	return cid.NewCidV1(cid.DagProtobuf, mh).String(), nil
+}
+
+func (n *MemoryNode) Unpin(ctx context.Context, path string) error {
+	c := strings.TrimPrefix(path, "/ipfs/")
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.pins[c] {
+		return fmt.Errorf("%s: %w", c, task.ErrNotPinned)
+	}
+	delete(n.pins, c)
+	return nil
+}
+
+func (n *MemoryNode) KeyGen(ctx context.Context, name string, keyType string) (string, error) {
+	var typ, bits int
+	switch keyType {
+	case "", "ed25519":
+		typ = crypto.Ed25519
+	case "secp256k1":
+		typ = crypto.Secp256k1
+	case "rsa":
+		typ, bits = crypto.RSA, 2048
+	default:
+		return "", fmt.Errorf("unrecognized key type: %s", keyType)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.keys[name]; ok {
+		return "", fmt.Errorf("key with name '%s' already exists", name)
+	}
+	sk, _, err := crypto.GenerateKeyPairWithReader(typ, bits, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	pid, err := peer.IDFromPublicKey(sk.GetPublic())
+	if err != nil {
+		return "", err
+	}
+	id := peer.ToCid(pid).String()
+	n.keys[name] = id
+	n.privKeys[id] = sk
+	return id, nil
+}
+
+func (n *MemoryNode) KeyRm(ctx context.Context, name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if name == "self" {
+		return fmt.Errorf("cannot remove key with name 'self'")
+	}
+	id, ok := n.keys[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, task.ErrKeyNotFound)
+	}
+	delete(n.keys, name)
+	delete(n.names, id)
+	delete(n.privKeys, id)
+	delete(n.records, id)
+	return nil
+}
+
+func (n *MemoryNode) Publish(ctx context.Context, path string, key string, lifetime time.Duration, ttl time.Duration) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	id, ok := n.keys[key]
+	if !ok {
+		return "", fmt.Errorf("no key named %s was found", key)
+	}
+	sk, ok := n.privKeys[id]
+	if !ok {
+		return "", fmt.Errorf("key %s can't sign records", key)
+	}
+	if !strings.HasPrefix(path, "/ipfs/") {
+		path = "/ipfs/" + path
+	}
+	var seq uint64
+	if old, err := ipnsrecord.Decode(n.records[id]); err == nil {
+		seq = old.Sequence + 1
+	}
+	record, err := ipnsrecord.Create(sk, path, seq, time.Now().Add(lifetime), ttl)
+	if err != nil {
+		return "", err
+	}
+	n.names[id] = path
+	n.records[id] = record
+	return id, nil
+}
+
+func (n *MemoryNode) Resolve(ctx context.Context, name string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	path, ok := n.names[strings.TrimPrefix(name, "/ipns/")]
+	if !ok {
+		return "", fmt.Errorf("could not resolve name %s", name)
+	}
+	return path, nil
+}
+
+func (n *MemoryNode) Provide(ctx context.Context, c string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.blocks[c]; !ok {
+		return fmt.Errorf("block %s not found locally, cannot provide", c)
+	}
+	n.provided[c] = true
+	return nil
+}
+
+// FindProviders only knows about the node itself.
+func (n *MemoryNode) FindProviders(ctx context.Context, c string, max int) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.provided[c] && max > 0 {
+		return []string{memoryPeerID}, nil
+	}
+	return nil, nil
+}
+
+func (n *MemoryNode) Connect(ctx context.Context, addr string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.connected = append(n.connected, addr)
+	return nil
+}
+
+func (n *MemoryNode) GC(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for c := range n.blocks {
+		if !n.pins[c] {
+			delete(n.blocks, c)
+		}
+	}
+	return nil
+}
+
+// Pinned reports whether a CID is pinned.
+func (n *MemoryNode) Pinned(c string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.pins[c]
+}
+
+// Keys returns the names of all the keys on the node, other than self.
+func (n *MemoryNode) Keys() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var names []string
+	for name := range n.keys {
+		if name != "self" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Connected returns every address Connect was called with.
+func (n *MemoryNode) Connected() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.connected...)
+}
+
+// Gateway serves the node's content the way an IPFS gateway would, under
+// /ipfs/{cid} and /ipns/{name}. Content that was garbage collected is a 404.
+// It also answers the Delegated Routing HTTP API under /routing/v1, with the
+// node as the only peer there is.
+func (n *MemoryNode) Gateway() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, "/routing/v1/") {
+			n.serveRouting(w, r)
+			return
+		}
+		if strings.HasPrefix(path, "/ipns/") {
+			resolved, err := n.Resolve(r.Context(), path)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			path = resolved
+		}
+
+		n.mu.Lock()
+		b, ok := n.blocks[strings.TrimPrefix(path, "/ipfs/")]
+		n.mu.Unlock()
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-IPFS-POP", "memory-local")
+		w.Write(b)
+	})
+}
+
+func (n *MemoryNode) serveRouting(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/routing/v1/"), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	id, _ := n.ID(r.Context())
+	self := []map[string]interface{}{{
+		"Schema":    "peer",
+		"ID":        id.ID,
+		"Addrs":     id.Addresses,
+		"Protocols": []string{"transport-bitswap"},
+	}}
+
+	n.mu.Lock()
+	provided := n.provided[parts[1]]
+	n.mu.Unlock()
+
+	switch {
+	case parts[0] == "providers" && provided:
+		writeJSON(w, map[string]interface{}{"Providers": self})
+	case parts[0] == "peers" && parts[1] == id.ID:
+		writeJSON(w, map[string]interface{}{"Peers": self})
+	case parts[0] == "ipns":
+		n.mu.Lock()
+		record, ok := n.records[parts[1]]
+		n.mu.Unlock()
+		if !ok {
+			http.Error(w, "no record for "+parts[1], http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipfs.ipns-record")
+		w.Write(record)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,179 @@
+// Package ipfs implements task.Node on top of an IPFS node.
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// ShellNode is a task.Node that talks to an IPFS daemon over its HTTP API.
+type ShellNode struct {
+	sh *shell.Shell
+}
+
+func NewShellNode(sh *shell.Shell) *ShellNode {
+	return &ShellNode{sh: sh}
+}
+
+func (n *ShellNode) ID(ctx context.Context) (task.NodeID, error) {
+	// sh.ID() can't be cancelled, and this is what health checks call
+	var id shell.IdOutput
+	if err := n.sh.Request("id").Exec(ctx, &id); err != nil {
+		return task.NodeID{}, err
+	}
+	return task.NodeID{
+		ID:        id.ID,
+		Addresses: id.Addresses,
+	}, nil
+}
+
+func (n *ShellNode) Add(ctx context.Context, r io.Reader, layout task.Layout) (string, error) {
+	if layout.IsDefault() {
+		return n.sh.Add(r)
+	}
+	opts := []shell.AddOpts{
+		shell.CidVersion(layout.CidVersion),
+		// the daemon turns raw leaves on for CIDv1 unless told otherwise
+		shell.RawLeaves(layout.RawLeaves),
+	}
+	if layout.Hash != "" {
+		opts = append(opts, shell.Hash(layout.Hash))
+	}
+	if layout.Chunker != "" {
+		opts = append(opts, chunker(layout.Chunker))
+	}
+	return n.sh.Add(r, opts...)
+}
+
+// chunker is missing from the shell's add options.
+func chunker(spec string) shell.AddOpts {
+	return func(rb *shell.RequestBuilder) error {
+		rb.Option("chunker", spec)
+		return nil
+	}
+}
+
+func (n *ShellNode) Unpin(ctx context.Context, path string) error {
+	err := n.sh.Unpin(path)
+	// the daemon answers "not pinned or pinned indirectly"
+	var shErr *shell.Error
+	if errors.As(err, &shErr) && strings.Contains(shErr.Message, "not pinned") {
+		return fmt.Errorf("%s: %w", path, task.ErrNotPinned)
+	}
+	return err
+}
+
+func (n *ShellNode) KeyGen(ctx context.Context, name string, keyType string) (string, error) {
+	var opts []shell.KeyOpt
+	if keyType != "" {
+		opts = append(opts, shell.KeyGen.Type(keyType))
+	}
+	key, err := n.sh.KeyGen(ctx, name, opts...)
+	if err != nil {
+		return "", err
+	}
+	return key.Id, nil
+}
+
+func (n *ShellNode) KeyRm(ctx context.Context, name string) error {
+	_, err := n.sh.KeyRm(ctx, name)
+	// the daemon answers "no key named <name> was found"
+	var shErr *shell.Error
+	if errors.As(err, &shErr) && strings.Contains(shErr.Message, "no key named") {
+		return fmt.Errorf("%s: %w", name, task.ErrKeyNotFound)
+	}
+	return err
+}
+
+func (n *ShellNode) Publish(ctx context.Context, path string, key string, lifetime time.Duration, ttl time.Duration) (string, error) {
+	resp, err := n.sh.PublishWithDetails(path, key, lifetime, ttl, true)
+	if err != nil {
+		return "", err
+	}
+	return resp.Name, nil
+}
+
+func (n *ShellNode) Resolve(ctx context.Context, name string) (string, error) {
+	return n.sh.Resolve(name)
+}
+
+func (n *ShellNode) Provide(ctx context.Context, c string) error {
+	resp, err := n.sh.Request("routing/provide", c).Send(ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		return resp.Error
+	}
+	// the response streams query events until the provide is done
+	_, err = io.Copy(ioutil.Discard, resp.Output)
+	return err
+}
+
+// providerEvent is the routing query event findprovs streams back.
+type providerEvent struct {
+	Type      int
+	Responses []struct {
+		ID string
+	}
+}
+
+// the query event type of an event carrying providers
+const providerEventType = 4
+
+func (n *ShellNode) FindProviders(ctx context.Context, c string, max int) ([]string, error) {
+	resp, err := n.sh.Request("routing/findprovs", c).Option("num-providers", max).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	var providers []string
+	dec := json.NewDecoder(resp.Output)
+	for {
+		var ev providerEvent
+		err := dec.Decode(&ev)
+		if err == io.EOF {
+			return providers, nil
+		}
+		if err != nil {
+			return providers, err
+		}
+		if ev.Type != providerEventType {
+			continue
+		}
+		for _, r := range ev.Responses {
+			providers = append(providers, r.ID)
+		}
+	}
+}
+
+func (n *ShellNode) Connect(ctx context.Context, addr string) error {
+	return n.sh.SwarmConnect(ctx, addr)
+}
+
+func (n *ShellNode) GC(ctx context.Context) error {
+	resp, err := n.sh.Request("repo/gc").Send(ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+// Package ipnsrecord creates, decodes and verifies IPNS records, as served by
+// the Delegated Routing HTTP API. It supports just enough of the IpnsEntry
+// protobuf and its DAG-CBOR data to check what a record points at and who
+// signed it.
+package ipnsrecord
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Record is a decoded IPNS record.
+type Record struct {
+	// Value is the path the record points at.
+	Value    string
+	Validity time.Time
+	Sequence uint64
+	TTL      time.Duration
+
+	// the signed parts, as they were on the wire
+	valueV1     []byte
+	validityV1  []byte
+	signatureV1 []byte
+	signatureV2 []byte
+	data        []byte
+	pubKey      []byte
+}
+
+// Field numbers of the IpnsEntry protobuf message.
+const (
+	entryValue        = 1
+	entrySignatureV1  = 2
+	entryValidityType = 3
+	entryValidity     = 4
+	entrySequence     = 5
+	entryTTL          = 6
+	entryPubKey       = 7
+	entrySignatureV2  = 8
+	entryData         = 9
+)
+
+// validityEOL is the only validity type: the record expires at Validity.
+const validityEOL = 0
+
+const signatureV2Prefix = "ipns-signature:"
+
+// Create signs a record pointing name's key sk at value until eol. It carries
+// both the V1 and V2 signatures, as nodes still publish them.
+func Create(sk crypto.PrivKey, value string, seq uint64, eol time.Time, ttl time.Duration) ([]byte, error) {
+	validity := []byte(eol.UTC().Format(time.RFC3339Nano))
+
+	data := encodeData(value, validity, seq, ttl)
+	sigV2, err := sk.Sign(append([]byte(signatureV2Prefix), data...))
+	if err != nil {
+		return nil, err
+	}
+	sigV1, err := sk.Sign(signedV1([]byte(value), validity))
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, entryValue, protowire.BytesType)
+	b = protowire.AppendString(b, value)
+	b = protowire.AppendTag(b, entrySignatureV1, protowire.BytesType)
+	b = protowire.AppendBytes(b, sigV1)
+	b = protowire.AppendTag(b, entryValidityType, protowire.VarintType)
+	b = protowire.AppendVarint(b, validityEOL)
+	b = protowire.AppendTag(b, entryValidity, protowire.BytesType)
+	b = protowire.AppendBytes(b, validity)
+	b = protowire.AppendTag(b, entrySequence, protowire.VarintType)
+	b = protowire.AppendVarint(b, seq)
+	b = protowire.AppendTag(b, entryTTL, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(ttl))
+
+	// keys too large to be inlined in the name have to come along
+	id, err := peer.IDFromPublicKey(sk.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := id.ExtractPublicKey(); err == peer.ErrNoPublicKey {
+		pk, err := crypto.MarshalPublicKey(sk.GetPublic())
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, entryPubKey, protowire.BytesType)
+		b = protowire.AppendBytes(b, pk)
+	}
+
+	b = protowire.AppendTag(b, entrySignatureV2, protowire.BytesType)
+	b = protowire.AppendBytes(b, sigV2)
+	b = protowire.AppendTag(b, entryData, protowire.BytesType)
+	b = protowire.AppendBytes(b, data)
+	return b, nil
+}
+
+// Decode parses a record. The V2 data is authoritative when present, and the
+// V1 value then has to agree with it. Decode doesn't check the signature, see
+// Verify for that.
+func Decode(b []byte) (*Record, error) {
+	r := new(Record)
+	var validityType uint64
+	err := fields(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case entryValue:
+			r.valueV1 = v
+		case entrySignatureV1:
+			r.signatureV1 = v
+		case entryValidityType:
+			validityType, _ = protowire.ConsumeVarint(v)
+		case entryValidity:
+			r.validityV1 = v
+		case entrySequence:
+			r.Sequence, _ = protowire.ConsumeVarint(v)
+		case entryTTL:
+			ttl, _ := protowire.ConsumeVarint(v)
+			r.TTL = time.Duration(ttl)
+		case entryPubKey:
+			r.pubKey = v
+		case entrySignatureV2:
+			r.signatureV2 = v
+		case entryData:
+			r.data = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("record is not an IpnsEntry: %w", err)
+	}
+
+	value, validity := r.valueV1, r.validityV1
+	if r.data != nil {
+		d, err := decodeData(r.data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record data: %w", err)
+		}
+		if r.valueV1 != nil && !bytes.Equal(r.valueV1, d.value) {
+			return nil, fmt.Errorf("V1 value %q doesn't match the data's %q", r.valueV1, d.value)
+		}
+		value, validity, validityType = d.value, d.validity, d.validityType
+		r.Sequence, r.TTL = d.sequence, d.ttl
+	}
+	if validityType != validityEOL {
+		return nil, fmt.Errorf("unknown validity type %d", validityType)
+	}
+	if len(value) == 0 {
+		return nil, fmt.Errorf("record has no value")
+	}
+	r.Value = string(value)
+	r.Validity, err = time.Parse(time.RFC3339Nano, string(validity))
+	if err != nil {
+		return nil, fmt.Errorf("invalid validity %q: %w", validity, err)
+	}
+	return r, nil
+}
+
+// Verify checks the record was signed by the key of name, a peer ID or
+// libp2p-key CID. Records with data must carry a V2 signature; the V1
+// signature is only checked on records without.
+func (r *Record) Verify(name string) error {
+	id, err := peer.Decode(name)
+	if err != nil {
+		return fmt.Errorf("invalid IPNS name %q: %w", name, err)
+	}
+	var pk crypto.PubKey
+	if r.pubKey != nil {
+		if pk, err = crypto.UnmarshalPublicKey(r.pubKey); err != nil {
+			return fmt.Errorf("invalid public key in record: %w", err)
+		}
+		if !id.MatchesPublicKey(pk) {
+			return fmt.Errorf("public key in record is not the key of %s", name)
+		}
+	} else if pk, err = id.ExtractPublicKey(); err != nil {
+		return fmt.Errorf("no public key for %s: %w", name, err)
+	}
+
+	var ok bool
+	if r.data != nil {
+		ok, err = pk.Verify(append([]byte(signatureV2Prefix), r.data...), r.signatureV2)
+	} else {
+		ok, err = pk.Verify(signedV1(r.valueV1, r.validityV1), r.signatureV1)
+	}
+	if err != nil || !ok {
+		return fmt.Errorf("invalid signature for %s", name)
+	}
+	return nil
+}
+
+// signedV1 is what the V1 signature is over: the value, the validity and the
+// name of the validity type.
+func signedV1(value []byte, validity []byte) []byte {
+	return bytes.Join([][]byte{value, validity, []byte("EOL")}, nil)
+}
+
+// CBOR major types used in the record data.
+const (
+	cborUint  = 0
+	cborBytes = 2
+	cborText  = 3
+	cborMap   = 5
+)
+
+// encodeData builds the DAG-CBOR map the V2 signature is over. DAG-CBOR sorts
+// keys by length first, then bytewise.
+func encodeData(value string, validity []byte, seq uint64, ttl time.Duration) []byte {
+	b := cborHead(nil, cborMap, 5)
+	b = cborString(b, cborText, "TTL")
+	b = cborHead(b, cborUint, uint64(ttl))
+	b = cborString(b, cborText, "Value")
+	b = cborString(b, cborBytes, value)
+	b = cborString(b, cborText, "Sequence")
+	b = cborHead(b, cborUint, seq)
+	b = cborString(b, cborText, "Validity")
+	b = cborString(b, cborBytes, string(validity))
+	b = cborString(b, cborText, "ValidityType")
+	b = cborHead(b, cborUint, validityEOL)
+	return b
+}
+
+func cborHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= 0xff:
+		return append(b, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major<<5|27), n)
+}
+
+func cborString(b []byte, major byte, s string) []byte {
+	return append(cborHead(b, major, uint64(len(s))), s...)
+}
+
+type data struct {
+	value        []byte
+	validity     []byte
+	validityType uint64
+	sequence     uint64
+	ttl          time.Duration
+}
+
+// decodeData reads the fields of the record data. Fields it doesn't know are
+// skipped, as long as they are integers, byte or text strings.
+func decodeData(b []byte) (data, error) {
+	var d data
+	major, n, b, err := readCBORHead(b)
+	if err != nil {
+		return d, err
+	}
+	if major != cborMap {
+		return d, fmt.Errorf("data is not a map")
+	}
+	for i := uint64(0); i < n; i++ {
+		var key, v []byte
+		var num uint64
+		if major, num, b, err = readCBORHead(b); err != nil {
+			return d, err
+		}
+		if major != cborText || num > uint64(len(b)) {
+			return d, fmt.Errorf("invalid map key")
+		}
+		key, b = b[:num], b[num:]
+
+		if major, num, b, err = readCBORHead(b); err != nil {
+			return d, err
+		}
+		switch major {
+		case cborUint:
+		case cborBytes, cborText:
+			if num > uint64(len(b)) {
+				return d, fmt.Errorf("%s is cut short", key)
+			}
+			v, b = b[:num], b[num:]
+		default:
+			return d, fmt.Errorf("%s is of unsupported CBOR type %d", key, major)
+		}
+
+		switch string(key) {
+		case "Value":
+			d.value = v
+		case "Validity":
+			d.validity = v
+		case "ValidityType":
+			d.validityType = num
+		case "Sequence":
+			d.sequence = num
+		case "TTL":
+			d.ttl = time.Duration(num)
+		}
+	}
+	return d, nil
+}
+
+// readCBORHead reads the major type and argument of the next CBOR item,
+// returning what follows it.
+func readCBORHead(b []byte) (byte, uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, 0, nil, fmt.Errorf("data is cut short")
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	if info < 24 {
+		return major, uint64(info), b, nil
+	}
+	size := 0
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, 0, nil, fmt.Errorf("indefinite or reserved CBOR length %d", info)
+	}
+	if len(b) < size {
+		return 0, 0, nil, fmt.Errorf("data is cut short")
+	}
+	var n uint64
+	for _, c := range b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	return major, n, b[size:], nil
+}
+
+// fields calls f with the number and raw value of each field in b. Values of
+// length-delimited fields come without their length.
+func fields(b []byte, f func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		v := b[:n]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		if err := f(num, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
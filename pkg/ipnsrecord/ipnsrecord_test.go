@@ -0,0 +1,95 @@
+package ipnsrecord
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func newKey(t *testing.T, typ int, bits int) (crypto.PrivKey, string) {
+	sk, _, err := crypto.GenerateKeyPairWithReader(typ, bits, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(sk.GetPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk, peer.ToCid(id).String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	keys := map[string][2]int{
+		"ed25519":   {crypto.Ed25519, 0},
+		"secp256k1": {crypto.Secp256k1, 0},
+		"rsa":       {crypto.RSA, 2048},
+	}
+	for name, k := range keys {
+		t.Run(name, func(t *testing.T) {
+			sk, ipnsName := newKey(t, k[0], k[1])
+			eol := time.Now().Add(time.Hour).Round(0)
+			b, err := Create(sk, "/ipfs/bafkqaaa", 3, eol, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Value != "/ipfs/bafkqaaa" || r.Sequence != 3 || r.TTL != time.Minute || !r.Validity.Equal(eol) {
+				t.Errorf("decoded %+v", r)
+			}
+			if err := r.Verify(ipnsName); err != nil {
+				t.Error(err)
+			}
+			// base58 peer IDs name the same key
+			id, _ := peer.Decode(ipnsName)
+			if err := r.Verify(id.String()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRejected(t *testing.T) {
+	sk, name := newKey(t, crypto.Ed25519, 0)
+	_, other := newKey(t, crypto.Ed25519, 0)
+	b, err := Create(sk, "/ipfs/bafkqaaa", 0, time.Now().Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(other); err == nil {
+		t.Error("expected a record signed by another key to be rejected")
+	}
+
+	// the V1 value no longer matches the signed data
+	swapped := bytes.Replace(b, []byte("/ipfs/bafkqaaa"), []byte("/ipfs/bafkqaab"), 1)
+	if _, err := Decode(swapped); err == nil {
+		t.Error("expected a changed V1 value to be rejected")
+	}
+
+	// and neither does the signature once the data changes too
+	both := bytes.ReplaceAll(b, []byte("/ipfs/bafkqaaa"), []byte("/ipfs/bafkqaab"))
+	r, err = Decode(both)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(name); err == nil {
+		t.Error("expected a changed record to fail verification")
+	}
+
+	for _, garbage := range [][]byte{nil, []byte("/ipfs/bafkqaaa"), b[:len(b)/2]} {
+		if _, err := Decode(garbage); err == nil {
+			t.Errorf("expected %q to be rejected", garbage)
+		}
+	}
+}
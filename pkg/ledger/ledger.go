@@ -0,0 +1,206 @@
+// Package ledger keeps track, on disk, of everything the monitor creates that
+// has to be cleaned up again: pins and keys on the local node, and pins on
+// pinning services. Tasks clean up after themselves, but if the monitor dies
+// mid-run, the ledger still knows what was left behind.
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kind is the type of artifact an entry stands for.
+type Kind string
+
+const (
+	// LocalPin is a pin on the local node. ID is the CID.
+	LocalPin Kind = "local_pin"
+	// Key is an IPNS key on the local node. ID is the key name.
+	Key Kind = "key"
+	// RemotePin is a pin on a pinning service. ID is the request ID, and
+	// Service the name of the pinning service.
+	RemotePin Kind = "remote_pin"
+)
+
+// Entry is one artifact created by a task.
+type Entry struct {
+	Kind    Kind      `json:"kind"`
+	ID      string    `json:"id"`
+	Service string    `json:"service,omitempty"`
+	Task    string    `json:"task"`
+	Size    int64     `json:"size,omitempty"`
+	Created time.Time `json:"created"`
+	// Attempts counts failed attempts to clean the artifact up.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+func (e Entry) key() string {
+	return string(e.Kind) + "/" + e.Service + "/" + e.ID
+}
+
+func (e Entry) String() string {
+	if e.Service != "" {
+		return fmt.Sprintf("%s %s on %s", e.Kind, e.ID, e.Service)
+	}
+	return fmt.Sprintf("%s %s", e.Kind, e.ID)
+}
+
+// state is what is written to disk.
+type state struct {
+	Entries []Entry `json:"entries"`
+	// Garbage is how many bytes were unpinned from the local node since it
+	// was last garbage collected.
+	Garbage int64 `json:"garbage"`
+}
+
+// Ledger is safe for concurrent use. Every change is written to disk before it
+// returns.
+type Ledger struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]Entry
+	garbage int64
+}
+
+// Open loads the ledger at path, creating it if it doesn't exist. If path is
+// empty, the ledger only lives in memory.
+func Open(path string) (*Ledger, error) {
+	l := &Ledger{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, l.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse ledger %s: %w", path, err)
+	}
+	for _, e := range s.Entries {
+		l.entries[e.key()] = e
+	}
+	l.garbage = s.Garbage
+	return l, nil
+}
+
+// Add records an artifact that was just created.
+func (l *Ledger) Add(e Entry) error {
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[e.key()] = e
+	return l.save()
+}
+
+// Remove records that an artifact was cleaned up. Removing a local pin adds
+// its size to the garbage waiting to be collected.
+func (l *Ledger) Remove(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stored, ok := l.entries[e.key()]
+	if !ok {
+		return nil
+	}
+	delete(l.entries, e.key())
+	if stored.Kind == LocalPin {
+		l.garbage += stored.Size
+	}
+	return l.save()
+}
+
+// Failed records a failed attempt to clean an artifact up, returning how many
+// attempts have failed so far.
+func (l *Ledger) Failed(e Entry) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stored, ok := l.entries[e.key()]
+	if !ok {
+		return 0, nil
+	}
+	stored.Attempts++
+	l.entries[e.key()] = stored
+	return stored.Attempts, l.save()
+}
+
+// Entries returns every artifact that hasn't been cleaned up, oldest first.
+func (l *Ledger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sorted()
+}
+
+// Garbage returns how many bytes were unpinned since the last garbage
+// collection.
+func (l *Ledger) Garbage() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.garbage
+}
+
+// Collected records that the local node was garbage collected.
+func (l *Ledger) Collected() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.garbage = 0
+	return l.save()
+}
+
+func (l *Ledger) sorted() []Entry {
+	entries := make([]Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	return entries
+}
+
+// save writes the ledger to a temporary file, then moves it into place, so a
+// crash mid-write can't leave it truncated.
+func (l *Ledger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(state{Entries: l.sorted(), Garbage: l.garbage}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(l.path), filepath.Base(l.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	return nil
+}
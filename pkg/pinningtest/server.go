@@ -0,0 +1,388 @@
+// Package pinningtest provides an in-memory stand-in for a Pinning Service API
+// endpoint, so tasks that talk to a pinning service can be exercised without a
+// real one.
+package pinningtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pinning "github.com/ipfs/go-pinning-service-http-client"
+)
+
+// DefaultDelegate is handed back as the delegate of every pin unless the
+// behaviour says otherwise.
+const DefaultDelegate = "/ip4/127.0.0.1/tcp/4001/p2p/QmcfgsJsMtx6qJb74akCw1M24X1zFwgGo11h1cuhwQjtJP"
+
+// Behaviour scripts how pins added to the server progress. It is captured when
+// a pin is added, so changing it only affects pins added afterwards.
+type Behaviour struct {
+	// QueuedFor is how long a new pin stays queued.
+	QueuedFor time.Duration
+	// PinningFor is how long the pin stays pinning once it leaves the queue.
+	PinningFor time.Duration
+	// Fail makes the pin end up failed instead of pinned.
+	Fail bool
+	// Delegates are returned with every pin status. Defaults to DefaultDelegate.
+	Delegates []string
+}
+
+// Pin is a pin request as stored by the server.
+type Pin struct {
+	RequestID string
+	Cid       string
+	Name      string
+	Origins   []string
+	Meta      map[string]string
+	Created   time.Time
+
+	behaviour Behaviour
+}
+
+// Status is the status of the pin at time now.
+func (p *Pin) Status(now time.Time) pinning.Status {
+	age := now.Sub(p.Created)
+	switch {
+	case age < p.behaviour.QueuedFor:
+		return pinning.StatusQueued
+	case age < p.behaviour.QueuedFor+p.behaviour.PinningFor:
+		return pinning.StatusPinning
+	case p.behaviour.Fail:
+		return pinning.StatusFailed
+	}
+	return pinning.StatusPinned
+}
+
+// Server is a Pinning Service API endpoint backed by a map. It is running as
+// soon as it is created. Call Close when done.
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mu         sync.Mutex
+	pins       map[string]*Pin
+	behaviour  Behaviour
+	failNext   int
+	failCode   int
+	rejectAuth bool
+	calls      map[string]int
+	lastAdded  time.Time
+}
+
+// NewServer starts a server that accepts requests bearing token.
+func NewServer(token string) *Server {
+	s := &Server{
+		Token: token,
+		pins:  make(map[string]*Pin),
+		calls: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns a pinning service client pointed at the server.
+func (s *Server) Client() *pinning.Client {
+	return pinning.NewClient(s.URL, s.Token)
+}
+
+// SetBehaviour changes how pins added from now on progress.
+func (s *Server) SetBehaviour(b Behaviour) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.behaviour = b
+}
+
+// FailRequests makes the next n requests fail with the given status code,
+// before they have any effect.
+func (s *Server) FailRequests(n int, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = n
+	s.failCode = code
+}
+
+// RejectAuth makes the server turn every request away as unauthorized, whether
+// or not it carries the right token.
+func (s *Server) RejectAuth(reject bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectAuth = reject
+}
+
+// Pins returns a snapshot of every pin on the server.
+func (s *Server) Pins() []Pin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pins := make([]Pin, 0, len(s.pins))
+	for _, p := range s.pins {
+		pins = append(pins, *p)
+	}
+	return pins
+}
+
+// Calls returns how many requests were made to an endpoint, keyed as in
+// "GET /pins" or "DELETE /pins/{requestid}".
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requestID := strings.TrimPrefix(r.URL.Path, "/pins/")
+	endpoint := r.Method + " /pins"
+	if r.URL.Path != "/pins" {
+		endpoint += "/{requestid}"
+	}
+	s.calls[endpoint]++
+
+	if s.rejectAuth || r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeFailure(w, http.StatusUnauthorized, "UNAUTHORIZED", "access token is missing or invalid")
+		return
+	}
+	if s.failNext > 0 {
+		s.failNext--
+		writeFailure(w, s.failCode, "INTERNAL_SERVER_ERROR", "scripted failure")
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/pins" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case r.URL.Path == "/pins" && r.Method == http.MethodPost:
+		s.add(w, r, "")
+	case strings.HasPrefix(r.URL.Path, "/pins/") && r.Method == http.MethodGet:
+		s.get(w, requestID)
+	case strings.HasPrefix(r.URL.Path, "/pins/") && r.Method == http.MethodPost:
+		s.add(w, r, requestID)
+	case strings.HasPrefix(r.URL.Path, "/pins/") && r.Method == http.MethodDelete:
+		s.delete(w, requestID)
+	default:
+		writeFailure(w, http.StatusNotFound, "NOT_FOUND", "no such endpoint")
+	}
+}
+
+type pinJSON struct {
+	Cid     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+type pinStatusJSON struct {
+	RequestID string            `json:"requestid"`
+	Status    string            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       pinJSON           `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info,omitempty"`
+}
+
+func (s *Server) status(p *Pin) pinStatusJSON {
+	delegates := p.behaviour.Delegates
+	if len(delegates) == 0 {
+		delegates = []string{DefaultDelegate}
+	}
+	return pinStatusJSON{
+		RequestID: p.RequestID,
+		Status:    p.Status(time.Now()).String(),
+		Created:   p.Created,
+		Pin: pinJSON{
+			Cid:     p.Cid,
+			Name:    p.Name,
+			Origins: p.Origins,
+			Meta:    p.Meta,
+		},
+		Delegates: delegates,
+	}
+}
+
+// add creates a pin. If replace is set, that pin is removed in the same step.
+func (s *Server) add(w http.ResponseWriter, r *http.Request, replace string) {
+	if replace != "" {
+		if _, ok := s.pins[replace]; !ok {
+			writeFailure(w, http.StatusNotFound, "NOT_FOUND", "no pin with that requestid")
+			return
+		}
+	}
+
+	var in pinJSON
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Cid == "" {
+		writeFailure(w, http.StatusBadRequest, "BAD_REQUEST", "body must be a pin object with a cid")
+		return
+	}
+
+	// created timestamps are used for pagination, so they must be unique
+	created := time.Now().UTC()
+	if !created.After(s.lastAdded) {
+		created = s.lastAdded.Add(time.Millisecond)
+	}
+	s.lastAdded = created
+
+	p := &Pin{
+		RequestID: newRequestID(),
+		Cid:       in.Cid,
+		Name:      in.Name,
+		Origins:   in.Origins,
+		Meta:      in.Meta,
+		Created:   created,
+		behaviour: s.behaviour,
+	}
+	if replace != "" {
+		delete(s.pins, replace)
+	}
+	s.pins[p.RequestID] = p
+	writeJSON(w, http.StatusAccepted, s.status(p))
+}
+
+func (s *Server) get(w http.ResponseWriter, requestID string) {
+	p, ok := s.pins[requestID]
+	if !ok {
+		writeFailure(w, http.StatusNotFound, "NOT_FOUND", "no pin with that requestid")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status(p))
+}
+
+func (s *Server) delete(w http.ResponseWriter, requestID string) {
+	if _, ok := s.pins[requestID]; !ok {
+		writeFailure(w, http.StatusNotFound, "NOT_FOUND", "no pin with that requestid")
+		return
+	}
+	delete(s.pins, requestID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	now := time.Now()
+
+	var cids, statuses map[string]bool
+	if v := q.Get("cid"); v != "" {
+		cids = setOf(strings.Split(v, ","))
+	}
+	// the spec defaults to pinned only
+	statuses = setOf([]string{pinning.StatusPinned.String()})
+	if v := q.Get("status"); v != "" {
+		statuses = setOf(strings.Split(v, ","))
+	}
+	name := q.Get("name")
+
+	var before, after time.Time
+	for param, t := range map[string]*time.Time{"before": &before, "after": &after} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeFailure(w, http.StatusBadRequest, "BAD_REQUEST", param+" must be an RFC3339 timestamp")
+			return
+		}
+		*t = parsed
+	}
+
+	limit := 10
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeFailure(w, http.StatusBadRequest, "BAD_REQUEST", "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	var meta map[string]string
+	if v := q.Get("meta"); v != "" {
+		var ok bool
+		if meta, ok = parseMeta(v); !ok {
+			writeFailure(w, http.StatusBadRequest, "BAD_REQUEST", "meta must be a JSON object")
+			return
+		}
+	}
+
+	var matched []*Pin
+	for _, p := range s.pins {
+		switch {
+		case cids != nil && !cids[p.Cid]:
+		case !statuses[p.Status(now).String()]:
+		case name != "" && p.Name != name:
+		case !before.IsZero() && !p.Created.Before(before):
+		case !after.IsZero() && !p.Created.After(after):
+		case !hasMeta(p.Meta, meta):
+		default:
+			matched = append(matched, p)
+		}
+	}
+	// newest first
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Created.After(matched[j].Created)
+	})
+
+	results := []pinStatusJSON{}
+	for i := 0; i < len(matched) && i < limit; i++ {
+		results = append(results, s.status(matched[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":   len(matched),
+		"results": results,
+	})
+}
+
+// parseMeta reads the meta filter, a JSON object as the spec asks for.
+func parseMeta(v string) (map[string]string, bool) {
+	var meta map[string]string
+	if err := json.Unmarshal([]byte(v), &meta); err != nil {
+		return nil, false
+	}
+	return meta, true
+}
+
+func hasMeta(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func setOf(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, i := range items {
+		set[i] = true
+	}
+	return set
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeFailure(w http.ResponseWriter, code int, reason string, details string) {
+	writeJSON(w, code, map[string]interface{}{
+		"error": map[string]string{
+			"reason":  reason,
+			"details": details,
+		},
+	})
+}
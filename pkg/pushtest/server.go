@@ -0,0 +1,165 @@
+// Package pushtest provides an in-memory stand-in for a Prometheus Pushgateway
+// and remote-write endpoint, so pushing metrics can be exercised without
+// either.
+package pushtest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/golang/snappy"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/remotewrite"
+)
+
+// WritePath is where the server accepts remote writes. Pushgateway pushes go
+// to /metrics/job/..., as on a real one.
+const WritePath = "/api/v1/write"
+
+// Push is a push received the way a Pushgateway receives it.
+type Push struct {
+	Method string
+	// Grouping is the job and any other grouping labels from the URL.
+	Grouping map[string]string
+	Families []*dto.MetricFamily
+}
+
+// Server records every push and remote write it receives. It is running as
+// soon as it is created. Call Close when done.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	pushes   []Push
+	series   []remotewrite.Series
+	failNext int
+	failCode int
+}
+
+// NewServer starts a server that accepts every push and remote write.
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// WriteURL is the remote-write endpoint of the server.
+func (s *Server) WriteURL() string {
+	return s.URL + WritePath
+}
+
+// FailRequests makes the next n requests fail with the given status code.
+func (s *Server) FailRequests(n int, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = n
+	s.failCode = code
+}
+
+// Pushes returns every Pushgateway push received so far.
+func (s *Server) Pushes() []Push {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Push(nil), s.pushes...)
+}
+
+// Series returns every remote-written sample received so far.
+func (s *Server) Series() []remotewrite.Series {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]remotewrite.Series(nil), s.series...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failNext > 0 {
+		s.failNext--
+		http.Error(w, "failing as asked", s.failCode)
+		return
+	}
+
+	switch {
+	case r.URL.Path == WritePath && r.Method == "POST":
+		series, err := s.decodeWrite(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.series = append(s.series, series...)
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(r.URL.Path, "/metrics/job/") && (r.Method == "PUT" || r.Method == "POST"):
+		push, err := s.decodePush(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.pushes = append(s.pushes, push)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) decodeWrite(r *http.Request) ([]remotewrite.Series, error) {
+	if r.Header.Get("Content-Encoding") != "snappy" {
+		return nil, fmt.Errorf("expected snappy encoding, got %q", r.Header.Get("Content-Encoding"))
+	}
+	compressed, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy body: %w", err)
+	}
+	return remotewrite.Decode(b)
+}
+
+func (s *Server) decodePush(r *http.Request) (Push, error) {
+	// /metrics/job/<job>{/<label>/<value>}, values ending in @base64 encoded
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/metrics/"), "/")
+	if len(parts)%2 != 0 {
+		return Push{}, fmt.Errorf("grouping labels without a value in %s", r.URL.Path)
+	}
+	grouping := make(map[string]string)
+	for i := 0; i < len(parts); i += 2 {
+		name, value := parts[i], parts[i+1]
+		if strings.HasSuffix(name, "@base64") {
+			name = strings.TrimSuffix(name, "@base64")
+			v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return Push{}, fmt.Errorf("invalid base64 value for %s: %w", name, err)
+			}
+			value = string(v)
+		}
+		grouping[name] = value
+	}
+
+	var families []*dto.MetricFamily
+	// the decoder buffers the body afresh for every family unless it already is
+	dec := expfmt.NewDecoder(bufio.NewReader(r.Body), expfmt.ResponseFormat(r.Header))
+	for {
+		mf := new(dto.MetricFamily)
+		err := dec.Decode(mf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Push{}, fmt.Errorf("invalid metrics: %w", err)
+		}
+		families = append(families, mf)
+	}
+	return Push{Method: r.Method, Grouping: grouping, Families: families}, nil
+}
@@ -0,0 +1,248 @@
+// Package remotewrite sends the metrics a prometheus.Gatherer holds to a
+// Prometheus remote-write endpoint.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Series is one sample of a time series. Labels include __name__.
+type Series struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+// Push gathers every metric from g and writes it to url, timestamped now, with
+// extra added to the labels of each series.
+//
+// Histograms and summaries are sent as their _bucket, _sum and _count series,
+// as Prometheus would have scraped them. Native histogram buckets are left
+// out.
+func Push(ctx context.Context, client *http.Client, url string, g prometheus.Gatherer, extra map[string]string) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+	series := Flatten(mfs, extra, time.Now())
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(snappy.Encode(nil, Encode(series))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Flatten turns metric families into the series Prometheus would have scraped
+// from them.
+func Flatten(mfs []*dto.MetricFamily, extra map[string]string, now time.Time) []Series {
+	ts := now.UnixNano() / int64(time.Millisecond)
+	var series []Series
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			add := func(suffix string, value float64, more ...string) {
+				labels := make(map[string]string, len(m.Label)+len(extra)+2)
+				for k, v := range extra {
+					labels[k] = v
+				}
+				for _, l := range m.Label {
+					labels[l.GetName()] = l.GetValue()
+				}
+				for i := 0; i+1 < len(more); i += 2 {
+					labels[more[i]] = more[i+1]
+				}
+				labels["__name__"] = name + suffix
+				series = append(series, Series{Labels: labels, Value: value, Timestamp: ts})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				add("_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Field numbers of the remote-write protobuf messages.
+const (
+	writeRequestTimeseries = 1
+	timeseriesLabels       = 1
+	timeseriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// Encode marshals series as a remote-write WriteRequest, with one time series
+// per sample.
+func Encode(series []Series) []byte {
+	var b []byte
+	for _, s := range series {
+		var ts []byte
+		names := make([]string, 0, len(s.Labels))
+		for k := range s.Labels {
+			names = append(names, k)
+		}
+		// receivers expect labels sorted by name
+		sort.Strings(names)
+		for _, k := range names {
+			var l []byte
+			l = protowire.AppendTag(l, labelName, protowire.BytesType)
+			l = protowire.AppendString(l, k)
+			l = protowire.AppendTag(l, labelValue, protowire.BytesType)
+			l = protowire.AppendString(l, s.Labels[k])
+			ts = protowire.AppendTag(ts, timeseriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, sampleValue, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, sampleTimestamp, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+		ts = protowire.AppendTag(ts, timeseriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
+
+// Decode unmarshals a WriteRequest, one Series per sample. Fields it doesn't
+// know, such as metadata and exemplars, are skipped.
+func Decode(b []byte) ([]Series, error) {
+	var series []Series
+	err := fields(b, func(num protowire.Number, v []byte) error {
+		if num != writeRequestTimeseries {
+			return nil
+		}
+		labels := make(map[string]string)
+		var samples []Series
+		err := fields(v, func(num protowire.Number, v []byte) error {
+			switch num {
+			case timeseriesLabels:
+				var name, value string
+				err := fields(v, func(num protowire.Number, v []byte) error {
+					switch num {
+					case labelName:
+						name = string(v)
+					case labelValue:
+						value = string(v)
+					}
+					return nil
+				})
+				labels[name] = value
+				return err
+			case timeseriesSamples:
+				var s Series
+				err := fields(v, func(num protowire.Number, v []byte) error {
+					switch num {
+					case sampleValue:
+						bits, _ := protowire.ConsumeFixed64(v)
+						s.Value = math.Float64frombits(bits)
+					case sampleTimestamp:
+						t, _ := protowire.ConsumeVarint(v)
+						s.Timestamp = int64(t)
+					}
+					return nil
+				})
+				samples = append(samples, s)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, s := range samples {
+			s.Labels = labels
+			series = append(series, s)
+		}
+		return nil
+	})
+	return series, err
+}
+
+// fields calls f with the number and raw value of each field in b. Values of
+// length-delimited fields come without their length.
+func fields(b []byte, f func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		v := b[:n]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		if err := f(num, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
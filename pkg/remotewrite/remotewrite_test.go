@@ -0,0 +1,91 @@
+// The test is in its own package, as pushtest decodes with this one.
+package remotewrite_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/pushtest"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/remotewrite"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	series := []remotewrite.Series{
+		{Labels: map[string]string{"__name__": "a", "test": "ipns", "job": "j"}, Value: 1.5, Timestamp: 1000},
+		{Labels: map[string]string{"__name__": "b_bucket", "le": "+Inf"}, Value: 3, Timestamp: 2000},
+	}
+	got, err := remotewrite.Decode(remotewrite.Encode(series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, series) {
+		t.Errorf("decoded %v, expected %v", got, series)
+	}
+}
+
+func TestPush(t *testing.T) {
+	srv := pushtest.NewServer()
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "runs_count"}, []string{"test"})
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:                        "fetch_seconds",
+		Buckets:                     []float64{1, 2},
+		NativeHistogramBucketFactor: 1.1,
+	})
+	reg.MustRegister(c, h)
+	c.WithLabelValues("ipns").Add(2)
+	h.Observe(1.5)
+
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	err := remotewrite.Push(context.Background(), http.DefaultClient, srv.WriteURL(), reg, map[string]string{"job": "j"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]float64)
+	for _, s := range srv.Series() {
+		if s.Labels["job"] != "j" {
+			t.Errorf("%v is missing the extra job label", s.Labels)
+		}
+		if s.Timestamp < before {
+			t.Errorf("%v is timestamped %d, before the push", s.Labels, s.Timestamp)
+		}
+		key := s.Labels["__name__"]
+		if le, ok := s.Labels["le"]; ok {
+			key += "{le=" + le + "}"
+		}
+		got[key] = s.Value
+	}
+	expected := map[string]float64{
+		"runs_count":                    2,
+		"fetch_seconds_bucket{le=1}":    0,
+		"fetch_seconds_bucket{le=2}":    1,
+		"fetch_seconds_bucket{le=+Inf}": 1,
+		"fetch_seconds_sum":             1.5,
+		"fetch_seconds_count":           1,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("received %v, expected %v", got, expected)
+	}
+}
+
+func TestPushFailure(t *testing.T) {
+	srv := pushtest.NewServer()
+	defer srv.Close()
+	srv.FailRequests(1, http.StatusServiceUnavailable)
+
+	err := remotewrite.Push(context.Background(), http.DefaultClient, srv.WriteURL(), prometheus.NewRegistry(), nil)
+	if err == nil {
+		t.Error("expected the push to fail")
+	}
+	if n := len(srv.Series()); n != 0 {
+		t.Errorf("received %d series from a failed push", n)
+	}
+}
@@ -0,0 +1,170 @@
+// Package resultlog writes the result of every probe as a line of JSON, so
+// the exact URLs, POPs and status codes behind a failure can be listed after
+// the fact.
+package resultlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Version is the version of the Result format. Fields are only ever added to
+// a version. Renaming or removing one bumps it.
+const Version = 1
+
+// Outcome is how a probe went.
+type Outcome string
+
+const (
+	// OK is a probe that got the expected response.
+	OK Outcome = "ok"
+	// Fail is a probe the gateway answered, but not as expected.
+	Fail Outcome = "fail"
+	// Stall is a probe the gateway stopped sending the body for.
+	Stall Outcome = "stall"
+	// Error is a probe that didn't get an answer at all.
+	Error Outcome = "error"
+)
+
+// Result is one probe of a gateway. Times are in seconds.
+type Result struct {
+	Version int       `json:"v"`
+	Time    time.Time `json:"time"`
+	Task    string    `json:"task"`
+	Target  string    `json:"target"`
+	URL     string    `json:"url"`
+	CID     string    `json:"cid,omitempty"`
+	Pop     string    `json:"pop"`
+	Status  int       `json:"status"`
+	Proto   string    `json:"proto,omitempty"`
+	Conn    string    `json:"conn,omitempty"`
+	// Labels are the task's other metric labels, such as dag or key_type,
+	// that tell its instances apart.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	TTFB    float64 `json:"ttfb_seconds"`
+	Total   float64 `json:"total_seconds"`
+	Bytes   int64   `json:"bytes"`
+	Outcome Outcome `json:"outcome"`
+	Error   string  `json:"error,omitempty"`
+	TraceID string  `json:"trace_id,omitempty"`
+	// RequestID is the ID sent to the gateway with the request, and
+	// ResponseIDs the IDs it answered with, by header.
+	RequestID   string            `json:"request_id,omitempty"`
+	ResponseIDs map[string]string `json:"response_ids,omitempty"`
+	// Payload is set when the probe fetched content the task generated.
+	Payload *Payload `json:"payload,omitempty"`
+}
+
+// Payload is what the payload command takes to regenerate the content a task
+// generated and added to IPFS.
+type Payload struct {
+	Seed int64 `json:"seed"`
+	Size int   `json:"size"`
+	// Dag is the layout the content was added with, as in the dag label,
+	// followed by its parameters. Empty ones were left to the node.
+	Dag        string `json:"dag"`
+	CidVersion int    `json:"cid_version"`
+	Hash       string `json:"hash,omitempty"`
+	Chunker    string `json:"chunker,omitempty"`
+	RawLeaves  bool   `json:"raw_leaves,omitempty"`
+}
+
+// Log writes results to a file, rotating it when it grows past a size, or to
+// stdout.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	out     io.Writer
+	file    *os.File
+	size    int64
+}
+
+// Open opens the log at path, appending to it. Once it grows past maxSize
+// bytes it's moved to path.1, path.1 to path.2 and so on, keeping keep old
+// files. A path of "-" writes to stdout, which is never rotated.
+func Open(path string, maxSize int64, keep int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, keep: keep}
+	if path == "-" {
+		l.out = os.Stdout
+		return l, nil
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open result log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open result log: %w", err)
+	}
+	l.file, l.out, l.size = f, f, info.Size()
+	return nil
+}
+
+func (l *Log) rotate() error {
+	l.file.Close()
+	for i := l.keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	var err error
+	if l.keep > 0 {
+		err = os.Rename(l.path, l.path+".1")
+	} else {
+		err = os.Remove(l.path)
+	}
+	// carry on writing either way
+	if err := l.open(); err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate result log: %w", err)
+	}
+	return nil
+}
+
+// Write writes r as one line, filling in its version.
+func (l *Log) Write(r Result) error {
+	r.Version = Version
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rotateErr error
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		rotateErr = l.rotate()
+	}
+	n, err := l.out.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// Close closes the file being written to.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,100 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Node is the IPFS node tasks publish content through. pkg/ipfs has an
+// implementation backed by the go-ipfs HTTP API, and an in-memory one for
+// tests.
+type Node interface {
+	// ID returns the node's peer ID and the addresses it listens on.
+	ID(ctx context.Context) (NodeID, error)
+	// Add adds and pins the content of r with the given layout, returning
+	// its CID.
+	Add(ctx context.Context, r io.Reader, layout Layout) (string, error)
+	// Unpin removes the pin on a CID or path. It returns ErrNotPinned if
+	// there is none.
+	Unpin(ctx context.Context, path string) error
+	// KeyGen creates a new named IPNS key of the given type, such as ed25519,
+	// rsa or secp256k1, returning its ID. An empty type is the node's default.
+	KeyGen(ctx context.Context, name string, keyType string) (string, error)
+	// KeyRm removes a named IPNS key. It returns ErrKeyNotFound if there is
+	// none.
+	KeyRm(ctx context.Context, name string) error
+	// Publish points the IPNS name of key at path, returning the name.
+	Publish(ctx context.Context, path string, key string, lifetime time.Duration, ttl time.Duration) (string, error)
+	// Resolve resolves an IPNS name to the path it points at.
+	Resolve(ctx context.Context, name string) (string, error)
+	// Provide announces to the routing system that the node has a CID.
+	Provide(ctx context.Context, c string) error
+	// FindProviders asks the routing system for up to max providers of a
+	// CID, returning their peer IDs.
+	FindProviders(ctx context.Context, c string, max int) ([]string, error)
+	// Connect opens a connection to a peer.
+	Connect(ctx context.Context, addr string) error
+	// GC garbage collects the node's repo.
+	GC(ctx context.Context) error
+}
+
+// ErrNotPinned is returned by Node.Unpin for content that isn't pinned, such as
+// content an earlier attempt already unpinned.
+var ErrNotPinned = errors.New("not pinned")
+
+// ErrKeyNotFound is returned by Node.KeyRm for keys that don't exist, such as
+// keys an earlier attempt already removed.
+var ErrKeyNotFound = errors.New("key not found")
+
+// NodeID identifies a node on the network.
+type NodeID struct {
+	ID        string
+	Addresses []string
+}
+
+// HealthReporter is implemented by nodes that keep track of whether they are
+// reachable.
+type HealthReporter interface {
+	Up() bool
+}
+
+// Layout is the DAG shape content is added with. The zero value leaves every
+// parameter at the node's default.
+type Layout struct {
+	// CidVersion is 0 or 1.
+	CidVersion int
+	// Hash is a multihash function name, such as sha2-256 or blake3.
+	Hash string
+	// Chunker is a chunker spec, such as size-262144, rabin or buzhash.
+	Chunker string
+	// RawLeaves stores leaf data as raw blocks rather than in UnixFS nodes.
+	RawLeaves bool
+}
+
+// IsDefault reports whether the layout leaves everything to the node.
+func (l Layout) IsDefault() bool {
+	return l == Layout{}
+}
+
+// String describes the layout for use as a label, as in
+// "v1-blake3-buzhash-raw", or "default" for the zero value.
+func (l Layout) String() string {
+	if l.IsDefault() {
+		return "default"
+	}
+	parts := []string{fmt.Sprintf("v%d", l.CidVersion)}
+	if l.Hash != "" {
+		parts = append(parts, l.Hash)
+	}
+	if l.Chunker != "" {
+		parts = append(parts, l.Chunker)
+	}
+	if l.RawLeaves {
+		parts = append(parts, "raw")
+	}
+	return strings.Join(parts, "-")
+}
@@ -2,9 +2,6 @@ package task
 
 import (
 	"context"
-
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -16,15 +13,15 @@ func (t *TerminalTask) Name() string {
 	return "terminal_task"
 }
 
-func (t *TerminalTask) LatencyHist() *prometheus.HistogramVec {
+func (t *TerminalTask) LatencyHist() prometheus.ObserverVec {
 	return nil
 }
 
-func (t *TerminalTask) FetchHist() *prometheus.HistogramVec {
+func (t *TerminalTask) FetchHist() prometheus.ObserverVec {
 	return nil
 }
 
-func (t *TerminalTask) Run(context.Context, *shell.Shell, *pinning.Client, string) error {
+func (t *TerminalTask) Run(context.Context, Node, PinningServices, string) error {
 	t.Done <- true
 	return nil
 }
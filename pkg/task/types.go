@@ -7,16 +7,15 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 
-	shell "github.com/ipfs/go-ipfs-api"
 	pinning "github.com/ipfs/go-pinning-service-http-client"
 )
 
 type Task interface {
 	Name() string
-	Run(context.Context, *shell.Shell, *pinning.Client, string) error
+	Run(context.Context, Node, PinningServices, string) error
 	Registration() *Registration
-	LatencyHist() *prometheus.HistogramVec
-	FetchHist() *prometheus.HistogramVec
+	LatencyHist() prometheus.ObserverVec
+	FetchHist() prometheus.ObserverVec
 }
 
 var popRegex = regexp.MustCompile("^[a-z0-9-]+-([a-z0-9]+)$")
@@ -42,4 +41,31 @@ func Labels(t Task, pop string, size int, code int) prometheus.Labels {
 type Registration struct {
 	Collectors []prometheus.Collector
 	Schedule   string
+	// NeedsNode is set by tasks that can't run without the local IPFS node.
+	// They are skipped while the node is down.
+	NeedsNode bool
 }
+
+// PinningService is a pinning service client along with the name its metrics
+// are labelled with. URL and Token are kept for the few requests the client
+// can't make, such as filtering by meta.
+type PinningService struct {
+	Name  string
+	URL   string
+	Token string
+	*pinning.Client
+}
+
+// NewPinningService returns a client for the pinning service at url.
+func NewPinningService(name, url, token string) PinningService {
+	return PinningService{
+		Name:   name,
+		URL:    url,
+		Token:  token,
+		Client: pinning.NewClient(url, token),
+	}
+}
+
+// PinningServices are all the pinning services the monitor is configured with.
+// Tasks that use a pinning service run against each of them.
+type PinningServices []PinningService
@@ -0,0 +1,106 @@
+// Package tracing sets up OpenTelemetry tracing of task runs, and ties the
+// spans to log lines and outgoing requests.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const instrumentation = "github.com/ipfs-shipyard/gateway-monitor"
+
+// Tracer is what tasks and the engine create spans with. Spans are dropped
+// unless Setup was called with somewhere to export them.
+var Tracer = otel.Tracer(instrumentation)
+
+// Config says where to export spans to. Either, both or neither can be set.
+type Config struct {
+	// OTLPEndpoint is the URL of an OTLP/HTTP collector, such as
+	// http://localhost:4318.
+	OTLPEndpoint string
+	// File is written one JSON object per span.
+	File string
+}
+
+// Setup starts exporting spans as c says. Call the returned func before
+// exiting to flush the spans still buffered.
+func Setup(ctx context.Context, c Config) (func(context.Context) error, error) {
+	// pass the trace on to gateways, whether or not it's exported
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var opts []sdktrace.TracerProviderOption
+	var closers []func() error
+	if c.OTLPEndpoint != "" {
+		exp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(c.OTLPEndpoint))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+	if c.File != "" {
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create trace file exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+		closers = append(closers, f.Close)
+	}
+	if len(opts) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts = append(opts, sdktrace.WithResource(resource.NewSchemaless(
+		attribute.String("service.name", "gateway-monitor"),
+	)))
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		for _, c := range closers {
+			c()
+		}
+		return err
+	}, nil
+}
+
+// Logger returns l with the ID of the trace in ctx, if there is one.
+func Logger(ctx context.Context, l *zap.SugaredLogger) *zap.SugaredLogger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return l
+	}
+	return l.With("trace_id", sc.TraceID().String())
+}
+
+// Inject adds a W3C traceparent header for the span in ctx to h.
+func Inject(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// End ends span, marking it as failed if err is set. Defer it with a pointer to
+// the function's named error result.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
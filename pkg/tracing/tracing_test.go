@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// recorded starts a span that is recorded in memory, not exported.
+func recorded(t *testing.T) context.Context {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewInMemoryExporter()))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	ctx, _ := tp.Tracer("test").Start(context.Background(), "run")
+	return ctx
+}
+
+func TestSetupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), Config{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Tracer.Start(context.Background(), "run")
+	failed := errors.New("failed")
+	End(span, &failed)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Name   string
+		Status struct{ Code string }
+	}
+	if err := json.Unmarshal(f, &got); err != nil {
+		t.Fatalf("trace file doesn't hold a span: %v\n%s", err, f)
+	}
+	if got.Name != "run" || got.Status.Code != codes.Error.String() {
+		t.Errorf("span in trace file is %+v, expected the failed run", got)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := zap.New(core).Sugar()
+
+	Logger(context.Background(), l).Info("untraced")
+	ctx := recorded(t)
+	Logger(ctx, l).Info("traced")
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log lines, got %d", len(entries))
+	}
+	if _, ok := entries[0].ContextMap()["trace_id"]; ok {
+		t.Error("log line outside of a trace has a trace_id")
+	}
+	want := trace.SpanContextFromContext(ctx).TraceID().String()
+	if got := entries[1].ContextMap()["trace_id"]; got != want {
+		t.Errorf("trace_id is %v, expected %s", got, want)
+	}
+}
+
+func TestInject(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := make(http.Header)
+	Inject(context.Background(), h)
+	if got := h.Get("traceparent"); got != "" {
+		t.Errorf("injected traceparent %q without a trace", got)
+	}
+
+	ctx := recorded(t)
+	Inject(ctx, h)
+	sc := trace.SpanContextFromContext(ctx)
+	got := strings.Split(h.Get("traceparent"), "-")
+	if len(got) != 4 || got[1] != sc.TraceID().String() || got[2] != sc.SpanID().String() {
+		t.Errorf("traceparent is %q, expected trace %s and span %s", h.Get("traceparent"), sc.TraceID(), sc.SpanID())
+	}
+}
@@ -0,0 +1,235 @@
+package tasks
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ledger"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
+)
+
+// Ledger records every pin and key tasks create until they are cleaned up
+// again. It only lives in memory unless ConfigureTasks opens one on disk.
+var Ledger, _ = ledger.Open("")
+
+// GCThreshold is how many bytes must have been unpinned from the local node
+// before gc actually garbage collects it.
+var GCThreshold int64 = 256 * miB
+
+// Artifacts that still can't be cleaned up after this many attempts are
+// dropped from the ledger, rather than retried forever.
+const maxCleanupAttempts = 5
+
+var (
+	cleanup_pending = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "cleanup",
+			Name:      "pending",
+			Help:      "artifacts left behind by earlier runs, as of the last cleanup",
+		},
+		[]string{"kind"})
+	cleanup_cleaned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "cleanup",
+			Name:      "cleaned_count",
+		},
+		[]string{"kind"})
+	cleanup_abandoned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "cleanup",
+			Name:      "abandoned_count",
+			Help:      "artifacts dropped from the ledger after repeatedly failing to clean them up",
+		},
+		[]string{"kind"})
+)
+
+// track adds an artifact to the ledger. The run carries on if that fails, the
+// artifact just won't be cleaned up after a crash.
+func track(t task.Task, e ledger.Entry) {
+	e.Task = t.Name()
+	if err := Ledger.Add(e); err != nil {
+		log.Warnw("failed to record artifact in ledger", "artifact", e, "err", err)
+	}
+}
+
+func untrack(e ledger.Entry) {
+	if err := Ledger.Remove(e); err != nil {
+		log.Warnw("failed to remove artifact from ledger", "artifact", e, "err", err)
+	}
+}
+
+// unpin unpins a CID a task added, and takes it off the ledger.
+func unpin(ctx context.Context, node task.Node, c string) (err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "cleanup_unpin", trace.WithAttributes(attribute.String("cid", c)))
+	defer tracing.End(span, &err)
+	if err := node.Unpin(ctx, c); err != nil {
+		return err
+	}
+	untrack(ledger.Entry{Kind: ledger.LocalPin, ID: c})
+	return nil
+}
+
+// Reconcile cleans up everything on the ledger. Tasks run one at a time, so
+// when no task is running, anything on the ledger was left behind by a run
+// that didn't finish.
+func Reconcile(ctx context.Context, node task.Node, ps task.PinningServices) error {
+	return reconcile(ctx, node, ps, ledger.LocalPin, ledger.Key, ledger.RemotePin)
+}
+
+// reconcile cleans up the artifacts on the ledger of the given kinds only.
+func reconcile(ctx context.Context, node task.Node, ps task.PinningServices, kinds ...ledger.Kind) error {
+	pending := make(map[ledger.Kind]int)
+	for _, k := range kinds {
+		pending[k] = 0
+	}
+	var entries []ledger.Entry
+	for _, e := range Ledger.Entries() {
+		if _, ok := pending[e.Kind]; ok {
+			entries = append(entries, e)
+			pending[e.Kind]++
+		}
+	}
+	for kind, n := range pending {
+		cleanup_pending.WithLabelValues(string(kind)).Set(float64(n))
+	}
+	if len(entries) > 0 {
+		logger(ctx).Infof("cleaning up %d artifacts left behind by earlier runs", len(entries))
+	}
+
+	var failed error
+	for _, e := range entries {
+		err := cleanUp(ctx, node, ps, e)
+		if err == nil {
+			logger(ctx).Infow("cleaned up artifact", "artifact", e, "task", e.Task, "created", e.Created)
+			untrack(e)
+			cleanup_cleaned.WithLabelValues(string(e.Kind)).Inc()
+			continue
+		}
+
+		attempts, _ := Ledger.Failed(e)
+		if attempts >= maxCleanupAttempts {
+			logger(ctx).Errorw("giving up on cleaning up artifact", "artifact", e, "attempts", attempts, "err", err)
+			untrack(e)
+			cleanup_abandoned.WithLabelValues(string(e.Kind)).Inc()
+			continue
+		}
+		logger(ctx).Warnw("failed to clean up artifact", "artifact", e, "attempts", attempts, "err", err)
+		failed = err
+	}
+	return failed
+}
+
+func cleanUp(ctx context.Context, node task.Node, ps task.PinningServices, e ledger.Entry) error {
+	switch e.Kind {
+	case ledger.LocalPin:
+		err := node.Unpin(ctx, e.ID)
+		if stderrors.Is(err, task.ErrNotPinned) {
+			logger(ctx).Infow("content was already unpinned", "artifact", e)
+			return nil
+		}
+		return err
+	case ledger.Key:
+		err := node.KeyRm(ctx, e.ID)
+		if stderrors.Is(err, task.ErrKeyNotFound) {
+			logger(ctx).Infow("key was already removed", "artifact", e)
+			return nil
+		}
+		return err
+	case ledger.RemotePin:
+		for _, svc := range ps {
+			if svc.Name == e.Service {
+				err := svc.DeleteByID(ctx, e.ID)
+				if pinningStatus(err) == http.StatusNotFound {
+					logger(ctx).Infow("pin was already gone", "artifact", e)
+					return nil
+				}
+				return err
+			}
+		}
+		return fmt.Errorf("pinning service %q is not configured", e.Service)
+	}
+	return fmt.Errorf("unknown artifact kind %q", e.Kind)
+}
+
+// LedgerCleanup periodically cleans up the pins and keys on the local node
+// that are on the ledger, then garbage collects it if that freed enough.
+// RemotePinCleanup takes care of pinning services, as they don't depend on the
+// node being up.
+type LedgerCleanup struct {
+	reg *task.Registration
+}
+
+func NewLedgerCleanup(schedule string) *LedgerCleanup {
+	return &LedgerCleanup{
+		reg: &task.Registration{
+			Schedule:  schedule,
+			NeedsNode: true,
+		},
+	}
+}
+
+func (t *LedgerCleanup) Name() string {
+	return "ledger_cleanup"
+}
+
+func (t *LedgerCleanup) LatencyHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *LedgerCleanup) FetchHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *LedgerCleanup) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	defer gc(ctx, node)
+	return reconcile(ctx, node, ps, ledger.LocalPin, ledger.Key)
+}
+
+func (t *LedgerCleanup) Registration() *task.Registration {
+	return t.reg
+}
+
+// RemotePinCleanup periodically deletes the pinning service pins that are on
+// the ledger. It keeps running while the local node is down.
+type RemotePinCleanup struct {
+	reg *task.Registration
+}
+
+func NewRemotePinCleanup(schedule string) *RemotePinCleanup {
+	return &RemotePinCleanup{
+		reg: &task.Registration{
+			Schedule: schedule,
+		},
+	}
+}
+
+func (t *RemotePinCleanup) Name() string {
+	return "remote_pin_cleanup"
+}
+
+func (t *RemotePinCleanup) LatencyHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *RemotePinCleanup) FetchHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *RemotePinCleanup) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	return reconcile(ctx, node, ps, ledger.RemotePin)
+}
+
+func (t *RemotePinCleanup) Registration() *task.Registration {
+	return t.reg
+}
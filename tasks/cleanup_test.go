@@ -0,0 +1,82 @@
+package tasks
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipfs"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ledger"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// withLedger gives the test an empty ledger of its own.
+func withLedger(t *testing.T) {
+	old := Ledger
+	l, err := ledger.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ledger = l
+	t.Cleanup(func() { Ledger = old })
+}
+
+func TestLedgerCleanup(t *testing.T) {
+	withLedger(t)
+	node := ipfs.NewMemoryNode()
+	pinned, err := node.Add(context.Background(), strings.NewReader("left behind"), task.Layout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.KeyGen(context.Background(), "left-behind", "ed25519"); err != nil {
+		t.Fatal(err)
+	}
+	cleanup := NewLedgerCleanup("* * * * *")
+	track(cleanup, ledger.Entry{Kind: ledger.LocalPin, ID: pinned})
+	track(cleanup, ledger.Entry{Kind: ledger.LocalPin, ID: "bafkqaaa"}) // already unpinned
+	track(cleanup, ledger.Entry{Kind: ledger.Key, ID: "left-behind"})
+	track(cleanup, ledger.Entry{Kind: ledger.Key, ID: "already-removed"})
+	remote := ledger.Entry{Kind: ledger.RemotePin, ID: "elsewhere", Service: "unconfigured"}
+	track(cleanup, remote)
+
+	if err := cleanup.Run(context.Background(), node, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if node.Pinned(pinned) {
+		t.Error("content is still pinned")
+	}
+	if keys := node.Keys(); len(keys) != 0 {
+		t.Errorf("keys %v are still there", keys)
+	}
+	if entries := Ledger.Entries(); len(entries) != 1 || entries[0].ID != remote.ID {
+		t.Errorf("ledger has %v, expected only the remote pin to be left for RemotePinCleanup", entries)
+	}
+}
+
+func TestRemotePinCleanup(t *testing.T) {
+	withLedger(t)
+	srv, ps := newPinningService(t, "cleanup")
+	live := addPin(t, ps)
+	gone := addPin(t, ps)
+	if err := ps.DeleteByID(context.Background(), gone.GetRequestId()); err != nil {
+		t.Fatal(err)
+	}
+	cleanup := NewRemotePinCleanup("* * * * *")
+	if cleanup.Registration().NeedsNode {
+		t.Error("remote pins shouldn't wait for the local node")
+	}
+	track(cleanup, ledger.Entry{Kind: ledger.RemotePin, ID: live.GetRequestId(), Service: ps.Name})
+	track(cleanup, ledger.Entry{Kind: ledger.RemotePin, ID: gone.GetRequestId(), Service: ps.Name})
+	track(cleanup, ledger.Entry{Kind: ledger.LocalPin, ID: "bafkqaaa"})
+
+	// the node is down
+	if err := cleanup.Run(context.Background(), nil, task.PinningServices{ps}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if pins := srv.Pins(); len(pins) != 0 {
+		t.Errorf("%d pins are still on the pinning service", len(pins))
+	}
+	if entries := Ledger.Entries(); len(entries) != 1 || entries[0].Kind != ledger.LocalPin {
+		t.Errorf("ledger has %v, expected only the local pin to be left", entries)
+	}
+}
@@ -0,0 +1,244 @@
+package tasks
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// ConnMode controls whether a task's requests to the gateway reuse connections.
+type ConnMode string
+
+const (
+	// ConnModeDefault defers to DefaultConnMode.
+	ConnModeDefault ConnMode = ""
+	// ConnModeCold opens a fresh connection for every request.
+	ConnModeCold ConnMode = "cold"
+	// ConnModeWarm keeps connections alive between requests and runs.
+	ConnModeWarm ConnMode = "warm"
+	// ConnModePaired measures every fetch twice: once over a fresh
+	// connection, then again over the same, now warm, connection.
+	ConnModePaired ConnMode = "paired"
+)
+
+// DefaultConnMode is used by tasks that don't pick a mode of their own.
+var DefaultConnMode = ConnModeWarm
+
+func ParseConnMode(s string) (ConnMode, error) {
+	switch m := ConnMode(s); m {
+	case ConnModeCold, ConnModeWarm, ConnModePaired:
+		return m, nil
+	}
+	return ConnModeDefault, fmt.Errorf("unknown connection mode %q. expected cold, warm or paired", s)
+}
+
+// Protocol selects the HTTP version a task talks to the gateway with.
+type Protocol string
+
+const (
+	// ProtocolDefault defers to DefaultProtocol.
+	ProtocolDefault Protocol = ""
+	// ProtocolAuto allows HTTP/1.1 or HTTP/2, whichever the gateway negotiates.
+	ProtocolAuto Protocol = "auto"
+	// ProtocolHTTP1 forces HTTP/1.1.
+	ProtocolHTTP1 Protocol = "http1"
+	// ProtocolHTTP2 forces HTTP/2. The gateway must be reached over TLS, and
+	// directly: HTTP_PROXY and HTTPS_PROXY are ignored.
+	ProtocolHTTP2 Protocol = "http2"
+	// ProtocolHTTP3 forces HTTP/3 over QUIC, which doesn't go through proxies
+	// either.
+	ProtocolHTTP3 Protocol = "http3"
+)
+
+// DefaultProtocol is used by tasks that don't pick a protocol of their own.
+var DefaultProtocol = ProtocolAuto
+
+func ParseProtocol(s string) (Protocol, error) {
+	switch p := Protocol(s); p {
+	case ProtocolAuto, ProtocolHTTP1, ProtocolHTTP2, ProtocolHTTP3:
+		return p, nil
+	}
+	return ProtocolDefault, fmt.Errorf("unknown protocol %q. expected auto, http1, http2 or http3", s)
+}
+
+// prober owns the HTTP clients a task fetches from the gateway with. Each task
+// has its own, so whether a request is made over a pooled connection doesn't
+// depend on which task happened to run before it.
+type prober struct {
+	connMode  ConnMode
+	protocol  Protocol
+	tlsConfig *tls.Config
+
+	once sync.Once
+	warm *http.Client
+	cold *http.Client
+}
+
+// probeConn is one measurement to take: which client to use, and the mode to
+// record it under. If fresh is set, the client's connections are closed once
+// the measurement is done.
+type probeConn struct {
+	mode   ConnMode
+	client *http.Client
+	fresh  bool
+}
+
+// SetConnMode picks the connection mode for this task.
+func (p *prober) SetConnMode(m ConnMode) {
+	p.connMode = m
+}
+
+func (p *prober) ConnMode() ConnMode {
+	if p.connMode == ConnModeDefault {
+		return DefaultConnMode
+	}
+	return p.connMode
+}
+
+// SetProtocol picks the HTTP version for this task.
+func (p *prober) SetProtocol(proto Protocol) {
+	p.protocol = proto
+}
+
+func (p *prober) Protocol() Protocol {
+	if p.protocol == ProtocolDefault {
+		return DefaultProtocol
+	}
+	return p.protocol
+}
+
+// SetTLSConfig overrides the TLS settings used to reach the gateway, for
+// example to trust the certificate of a local test server.
+func (p *prober) SetTLSConfig(cfg *tls.Config) {
+	p.tlsConfig = cfg
+}
+
+// SetTaskProtocols picks the HTTP version of the tasks in All by name, as given
+// with --task-protocol.
+func SetTaskProtocols(protocols map[string]Protocol) error {
+	for name, proto := range protocols {
+		found := false
+		for _, t := range All {
+			if t.Name() != name {
+				continue
+			}
+			p, ok := t.(interface{ SetProtocol(Protocol) })
+			if !ok {
+				return fmt.Errorf("task %s doesn't fetch from the gateway", name)
+			}
+			p.SetProtocol(proto)
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("no task is called %s", name)
+		}
+	}
+	return nil
+}
+
+func (p *prober) init() {
+	p.once.Do(func() {
+		p.warm = &http.Client{Transport: newTransport(p.Protocol(), p.tlsConfig, true)}
+		p.cold = &http.Client{Transport: newTransport(p.Protocol(), p.tlsConfig, false)}
+	})
+}
+
+// client returns a client that keeps its connections alive, for tasks that
+// time API calls rather than connection setup.
+func (p *prober) client() *http.Client {
+	p.init()
+	return p.warm
+}
+
+// conns returns the measurements to take for a single probe.
+func (p *prober) conns() []probeConn {
+	p.init()
+
+	switch p.ConnMode() {
+	case ConnModeCold:
+		return []probeConn{{ConnModeCold, p.cold, true}}
+	case ConnModePaired:
+		// drop whatever is pooled so the first request has to dial
+		p.warm.CloseIdleConnections()
+		return []probeConn{{ConnModeCold, p.warm, false}, {ConnModeWarm, p.warm, false}}
+	default:
+		return []probeConn{{ConnModeWarm, p.warm, false}}
+	}
+}
+
+func newTransport(proto Protocol, tlsConfig *tls.Config, keepAlive bool) http.RoundTripper {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+
+	switch proto {
+	case ProtocolHTTP2:
+		// HTTP/2 multiplexes everything over one connection, so there is no
+		// keep-alive switch. Cold probes close the connection after use instead.
+		return &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			DialTLSContext:  dialHTTP2,
+		}
+	case ProtocolHTTP3:
+		return &http3.Transport{
+			TLSClientConfig: tlsConfig,
+		}
+	}
+
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           gatewayDialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		DisableKeepAlives:     !keepAlive,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       0, // tasks run minutes apart. Leave it to the gateway to hang up.
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if proto == ProtocolHTTP1 {
+		// a non-nil, empty map disables HTTP/2 negotiation
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// gatewayDialer and tlsHandshakeTimeout bound connection setup to the gateway
+// for every protocol that runs over TCP.
+var gatewayDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// dialHTTP2 dials like the default transport does, with the same timeouts, and
+// checks that the gateway agreed to HTTP/2.
+func dialHTTP2(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	raw, err := gatewayDialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, cfg)
+	hsCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(hsCtx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+		conn.Close()
+		return nil, fmt.Errorf("http2: unexpected ALPN protocol %q; want %q", p, http2.NextProtoTLS)
+	}
+	return conn, nil
+}
@@ -0,0 +1,173 @@
+package tasks
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// collected returns every metric c collects that has all of the labels in
+// match, along with its labels.
+func collected(c prometheus.Collector, match prometheus.Labels) ([]*dto.Metric, []prometheus.Labels) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var metrics []*dto.Metric
+	var found []prometheus.Labels
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			continue
+		}
+		labels := make(prometheus.Labels)
+		for _, l := range pb.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		matches := true
+		for k, v := range match {
+			if labels[k] != v {
+				matches = false
+			}
+		}
+		if matches {
+			metrics = append(metrics, &pb)
+			found = append(found, labels)
+		}
+	}
+	return metrics, found
+}
+
+// metricLabels returns the labels of every metric c collects that has all of
+// the labels in match.
+func metricLabels(c prometheus.Collector, match prometheus.Labels) []prometheus.Labels {
+	_, found := collected(c, match)
+	return found
+}
+
+// newTLSGateway starts a TLS server answering every request with body, tagged
+// with pop. The returned config trusts its certificate.
+func newTLSGateway(t *testing.T, enableHTTP2 bool, pop string, body []byte) (*httptest.Server, *tls.Config) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-IPFS-POP", pop)
+		w.Write(body)
+	}))
+	srv.EnableHTTP2 = enableHTTP2
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	return srv, &tls.Config{RootCAs: roots}
+}
+
+func TestProtocolLabel(t *testing.T) {
+	body := []byte("Hello World!\r\n")
+	cases := []struct {
+		name        string
+		enableHTTP2 bool
+		protocol    Protocol
+		want        string
+	}{
+		{"auto-h2", true, ProtocolAuto, "HTTP/2.0"},
+		{"auto-h1", false, ProtocolAuto, "HTTP/1.1"},
+		{"forced-h1", true, ProtocolHTTP1, "HTTP/1.1"},
+		{"forced-h2", true, ProtocolHTTP2, "HTTP/2.0"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pop := "tls-" + c.name
+			srv, tlsConfig := newTLSGateway(t, c.enableHTTP2, pop, body)
+
+			check := NewKnownGoodCheck("* * * * *", map[string][]byte{"/ipfs/hello": body})
+			check.SetProtocol(c.protocol)
+			check.SetTLSConfig(tlsConfig)
+			if err := check.Run(context.Background(), nil, nil, srv.URL); err != nil {
+				t.Fatal(err)
+			}
+
+			found := metricLabels(probe_ttfb, prometheus.Labels{"test": check.Name(), "pop": pop})
+			if len(found) != 1 {
+				t.Fatalf("expected one probe for %s, got %v", pop, found)
+			}
+			if got := found[0]["proto"]; got != c.want {
+				t.Errorf("proto label is %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
+
+// newHTTP3Gateway serves like newTLSGateway, but over HTTP/3 on a UDP port.
+func newHTTP3Gateway(t *testing.T, pop string, body []byte) (string, *tls.Config) {
+	// borrow the test certificate of an httptest server
+	tlsSrv, tlsConfig := newTLSGateway(t, false, pop, body)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-IPFS-POP", pop)
+			w.Write(body)
+		}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: tlsSrv.TLS.Certificates}),
+	}
+	go srv.Serve(conn)
+	t.Cleanup(func() {
+		srv.Close()
+		conn.Close()
+	})
+	return "https://" + conn.LocalAddr().String(), tlsConfig
+}
+
+func TestProtocolHTTP3(t *testing.T) {
+	body := []byte("Hello World!\r\n")
+	pop := "quic"
+	url, tlsConfig := newHTTP3Gateway(t, pop, body)
+
+	check := NewKnownGoodCheck("* * * * *", map[string][]byte{"/ipfs/hello": body})
+	check.SetProtocol(ProtocolHTTP3)
+	check.SetTLSConfig(tlsConfig)
+	if err := check.Run(context.Background(), nil, nil, url); err != nil {
+		t.Fatal(err)
+	}
+
+	found := metricLabels(probe_ttfb, prometheus.Labels{"test": check.Name(), "pop": pop})
+	if len(found) != 1 {
+		t.Fatalf("expected one probe for %s, got %v", pop, found)
+	}
+	if got := found[0]["proto"]; got != "HTTP/3.0" {
+		t.Errorf("proto label is %q, expected HTTP/3.0", got)
+	}
+}
+
+func TestProtocolForcedHTTP2WithoutSupport(t *testing.T) {
+	body := []byte("Hello World!\r\n")
+	srv, tlsConfig := newTLSGateway(t, false, "tls-no-h2", body)
+
+	check := NewKnownGoodCheck("* * * * *", map[string][]byte{"/ipfs/hello": body})
+	check.SetProtocol(ProtocolHTTP2)
+	check.SetTLSConfig(tlsConfig)
+	if err := check.Run(context.Background(), nil, nil, srv.URL); err == nil {
+		t.Fatal("expected forcing HTTP/2 to fail against a server that only speaks HTTP/1.1")
+	}
+}
+
+func TestSetTaskProtocols(t *testing.T) {
+	if err := SetTaskProtocols(map[string]Protocol{"no_such_task": ProtocolHTTP1}); err == nil {
+		t.Error("expected an unknown task to be rejected")
+	}
+	if err := SetTaskProtocols(map[string]Protocol{"pin_sweeper": ProtocolHTTP1}); err == nil {
+		t.Error("expected a task that doesn't fetch from the gateway to be rejected")
+	}
+}
@@ -0,0 +1,209 @@
+package tasks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HistogramConfig replaces the buckets a task's histogram was created with,
+// or turns it into a summary. It is one entry of the --histograms-file.
+//
+// Configs apply to a metric as a whole. Instances of a task share their
+// histograms, and every task shares the probe histograms, so there is no
+// configuring them for one instance, or one test, only.
+type HistogramConfig struct {
+	// Test is the task, Histogram the name of its metric without the
+	// gatewaymonitor_task_<test>_ prefix, such as latency_seconds. Leave Test
+	// empty for the gatewaymonitor_probe_* histograms, and name them as
+	// probe_ttfb_seconds or probe_fetch_seconds.
+	Test      string `json:"test"`
+	Histogram string `json:"histogram"`
+	// Gateway limits the entry to runs against one gateway. It takes
+	// precedence over an entry without one.
+	Gateway string `json:"gateway,omitempty"`
+
+	// Type is linear, exponential, explicit or summary.
+	Type string `json:"type"`
+	// Start, Width and Factor are the first bucket and the distance, or
+	// ratio, between buckets for linear and exponential buckets.
+	Start  float64 `json:"start,omitempty"`
+	Width  float64 `json:"width,omitempty"`
+	Factor float64 `json:"factor,omitempty"`
+	Count  int     `json:"count,omitempty"`
+	// Buckets are the upper bounds of explicit buckets.
+	Buckets []float64 `json:"buckets,omitempty"`
+	// Quantiles are what a summary reports. 0.5, 0.9 and 0.99 by default.
+	Quantiles []float64 `json:"quantiles,omitempty"`
+}
+
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+func (c HistogramConfig) name() string {
+	if c.Test == "" {
+		return prometheus.BuildFQName("gatewaymonitor", "", c.Histogram)
+	}
+	return prometheus.BuildFQName("gatewaymonitor_task", c.Test, c.Histogram)
+}
+
+func (c HistogramConfig) buckets() ([]float64, error) {
+	switch c.Type {
+	case "linear":
+		if c.Count < 1 || c.Width <= 0 {
+			return nil, fmt.Errorf("linear buckets need a positive width and count")
+		}
+		return prometheus.LinearBuckets(c.Start, c.Width, c.Count), nil
+	case "exponential":
+		if c.Count < 1 || c.Start <= 0 || c.Factor <= 1 {
+			return nil, fmt.Errorf("exponential buckets need a positive start and count, and a factor above 1")
+		}
+		return prometheus.ExponentialBuckets(c.Start, c.Factor, c.Count), nil
+	case "explicit":
+		if len(c.Buckets) == 0 {
+			return nil, fmt.Errorf("explicit buckets need at least one bucket")
+		}
+		if !sort.SliceIsSorted(c.Buckets, func(i, j int) bool { return c.Buckets[i] < c.Buckets[j] }) {
+			return nil, fmt.Errorf("explicit buckets must be in increasing order")
+		}
+		return c.Buckets, nil
+	}
+	return nil, fmt.Errorf("unknown histogram type %q", c.Type)
+}
+
+// objectives are the quantiles of a summary, each with an error of a tenth of
+// its distance from 0 or 1.
+func (c HistogramConfig) objectives() (map[float64]float64, error) {
+	quantiles := c.Quantiles
+	if len(quantiles) == 0 {
+		quantiles = defaultQuantiles
+	}
+	objectives := make(map[float64]float64, len(quantiles))
+	for _, q := range quantiles {
+		if q <= 0 || q >= 1 {
+			return nil, fmt.Errorf("quantile %v is not between 0 and 1", q)
+		}
+		e := q
+		if 1-q < e {
+			e = 1 - q
+		}
+		objectives[q] = e / 10
+	}
+	return objectives, nil
+}
+
+func (c HistogramConfig) validate() error {
+	if c.Type == "summary" {
+		_, err := c.objectives()
+		return err
+	}
+	_, err := c.buckets()
+	return err
+}
+
+// histogramConfigs are the configs picked by ConfigureHistograms, by metric
+// name.
+var histogramConfigs = make(map[string]HistogramConfig)
+
+// ConfigureHistograms picks the configs that apply when monitoring gw. It has
+// to be called before the tasks' collectors are registered, as that settles
+// their buckets.
+func ConfigureHistograms(configs []HistogramConfig, gw string) error {
+	histogramsMu.Lock()
+	defer histogramsMu.Unlock()
+
+	picked := make(map[string]HistogramConfig)
+	for _, c := range configs {
+		name := c.name()
+		if _, ok := histograms[name]; !ok {
+			return fmt.Errorf("no task has a histogram %s", name)
+		}
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if c.Gateway != "" && strings.TrimSuffix(c.Gateway, "/") != strings.TrimSuffix(gw, "/") {
+			continue
+		}
+		if prev, ok := picked[name]; ok && prev.Gateway != "" && c.Gateway == "" {
+			continue
+		}
+		picked[name] = c
+	}
+	histogramConfigs = picked
+	return nil
+}
+
+// taskHistogram is a histogram a task records to, or the summary standing in
+// for it. The underlying metric is only created when it's first registered or
+// observed, so that ConfigureHistograms can change it after All was created.
+type taskHistogram struct {
+	opts   prometheus.HistogramOpts
+	labels []string
+
+	once sync.Once
+	vec  prometheus.ObserverVec
+}
+
+func (h *taskHistogram) get() prometheus.ObserverVec {
+	h.once.Do(func() {
+		histogramsMu.Lock()
+		c, ok := histogramConfigs[prometheus.BuildFQName(h.opts.Namespace, h.opts.Subsystem, h.opts.Name)]
+		histogramsMu.Unlock()
+		if !ok {
+			h.vec = prometheus.NewHistogramVec(h.opts, h.labels)
+			return
+		}
+		if c.Type == "summary" {
+			// validated by ConfigureHistograms
+			objectives, _ := c.objectives()
+			h.vec = prometheus.NewSummaryVec(
+				prometheus.SummaryOpts{
+					Namespace:  h.opts.Namespace,
+					Subsystem:  h.opts.Subsystem,
+					Name:       h.opts.Name,
+					Help:       h.opts.Help,
+					Objectives: objectives,
+				},
+				h.labels)
+			return
+		}
+		opts := h.opts
+		opts.Buckets, _ = c.buckets()
+		h.vec = prometheus.NewHistogramVec(opts, h.labels)
+	})
+	return h.vec
+}
+
+func (h *taskHistogram) Describe(ch chan<- *prometheus.Desc) {
+	h.get().Describe(ch)
+}
+
+func (h *taskHistogram) Collect(ch chan<- prometheus.Metric) {
+	h.get().Collect(ch)
+}
+
+func (h *taskHistogram) GetMetricWith(labels prometheus.Labels) (prometheus.Observer, error) {
+	return h.get().GetMetricWith(labels)
+}
+
+func (h *taskHistogram) GetMetricWithLabelValues(lvs ...string) (prometheus.Observer, error) {
+	return h.get().GetMetricWithLabelValues(lvs...)
+}
+
+func (h *taskHistogram) With(labels prometheus.Labels) prometheus.Observer {
+	return h.get().With(labels)
+}
+
+func (h *taskHistogram) WithLabelValues(lvs ...string) prometheus.Observer {
+	return h.get().WithLabelValues(lvs...)
+}
+
+func (h *taskHistogram) CurryWith(labels prometheus.Labels) (prometheus.ObserverVec, error) {
+	return h.get().CurryWith(labels)
+}
+
+func (h *taskHistogram) MustCurryWith(labels prometheus.Labels) prometheus.ObserverVec {
+	return h.get().MustCurryWith(labels)
+}
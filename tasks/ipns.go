@@ -2,127 +2,230 @@ package tasks
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ledger"
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
 )
 
+// IpnsParams are the key type and record parameters an IpnsBench publishes
+// with.
+type IpnsParams struct {
+	// KeyType is ed25519, rsa or secp256k1.
+	KeyType  string
+	Lifetime time.Duration
+	TTL      time.Duration
+}
+
+var defaultIpnsParams = IpnsParams{
+	KeyType:  "ed25519",
+	Lifetime: time.Hour,
+	TTL:      time.Hour,
+}
+
+// ipnsMatrix returns every record in records for each of the key types.
+func ipnsMatrix(keyTypes []string, records []IpnsParams) []IpnsParams {
+	var params []IpnsParams
+	for _, kt := range keyTypes {
+		for _, r := range records {
+			r.KeyType = kt
+			params = append(params, r)
+		}
+	}
+	return params
+}
+
+// withIpnsParams creates one IpnsBench per set of parameters, passing newTask
+// its index.
+func withIpnsParams(params []IpnsParams, newTask func(i int) *IpnsBench) []task.Task {
+	tasks := make([]task.Task, 0, len(params))
+	for i, p := range params {
+		t := newTask(i)
+		t.SetParams(p)
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// Keys are named after the payload, so a key left behind can be traced back to
+// the run that created it.
+const ipnsKeyPrefix = "gateway-monitor-ipns-"
+
 type IpnsBench struct {
+	prober
+	publisher
+
 	reg          *task.Registration
 	size         int
-	publish_time prometheus.Histogram
-	latency      *prometheus.HistogramVec
-	fetch_time   *prometheus.HistogramVec
+	params       IpnsParams
+	publish_time prometheus.ObserverVec
+	latency      prometheus.ObserverVec
+	fetch_time   prometheus.ObserverVec
 }
 
 func NewIpnsBench(schedule string, size int) *IpnsBench {
-	publish_time := prometheus.NewHistogram(
+	publish_time := sharedHistogramVec(
 		prometheus.HistogramOpts{
-			Namespace:   "gatewaymonitor_task",
-			Subsystem:   "ipns",
-			Name:        "publish_seconds",
-			Buckets:     prometheus.LinearBuckets(0, 12, 11), // 0-2 minutes
-			ConstLabels: map[string]string{"size": strconv.Itoa(size)},
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "ipns",
+			Name:      "publish_seconds",
+			Buckets:   prometheus.LinearBuckets(0, 12, 11), // 0-2 minutes
 		},
-	)
+		[]string{"size", "dag", "key_type", "ttl"})
 
-	latency := prometheus.NewHistogramVec(
+	latency := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "ipns",
 			Name:      "latency_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 12, 11), // 0-2 minutes
 		},
-		defaultLabels)
+		labelNames(requestLabels, "dag", "key_type", "ttl"))
 
-	fetch_time := prometheus.NewHistogramVec(
+	fetch_time := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "ipns",
 			Name:      "fetch_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 15, 16), // 0-4 minutes
 		},
-		defaultLabels)
+		labelNames(requestLabels, "dag", "key_type", "ttl"))
 
 	reg := task.Registration{
-		Schedule: schedule,
+		Schedule:  schedule,
+		NeedsNode: true,
 		Collectors: []prometheus.Collector{
 			publish_time,
 			latency,
 			fetch_time,
+			probe_ttfb,
+			probe_fetch,
 		},
 	}
 	return &IpnsBench{
 		reg:          &reg,
 		size:         size,
+		params:       defaultIpnsParams,
 		publish_time: publish_time,
 		latency:      latency,
 		fetch_time:   fetch_time,
 	}
 }
 
+// SetParams picks the key type and record parameters for this task.
+func (t *IpnsBench) SetParams(p IpnsParams) {
+	t.params = p
+}
+
+func (t *IpnsBench) variantLabels() prometheus.Labels {
+	return prometheus.Labels{
+		"dag":      t.Layout().String(),
+		"key_type": t.params.KeyType,
+		"ttl":      t.params.TTL.String(),
+	}
+}
+
 func (t *IpnsBench) Name() string {
 	return "ipns"
 }
 
-func (t *IpnsBench) LatencyHist() *prometheus.HistogramVec {
+func (t *IpnsBench) LatencyHist() prometheus.ObserverVec {
 	return t.latency
 }
 
-func (t *IpnsBench) FetchHist() *prometheus.HistogramVec {
+func (t *IpnsBench) FetchHist() prometheus.ObserverVec {
 	return t.fetch_time
 }
 
-func (t *IpnsBench) Run(ctx context.Context, sh *shell.Shell, ps *pinning.Client, gw string) error {
-	defer gc(ctx, sh)
+func (t *IpnsBench) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	defer gc(ctx, node)
 
-	localLabels := prometheus.Labels{"test": "ipns", "size": strconv.Itoa(t.size), "pop": "localhost"}
+	localLabels := task.Labels(t, "localhost", t.size, 0)
 
-	cidstr, randb, err := addRandomData(sh, t, t.size)
+	cidstr, p, err := addRandomData(ctx, node, t, t.size)
 	if err != nil {
 		return err
 	}
 
 	defer func() {
-		log.Info("Unpinning test CID")
-		err := sh.Unpin(cidstr)
+		logger(ctx).Info("Unpinning test CID")
+		err := unpin(ctx, node, cidstr)
 		if err != nil {
 			errors.With(localLabels).Inc()
-			log.Warnw("failed to clean unpin cid.", "cid", cidstr)
+			logger(ctx).Warnw("failed to clean unpin cid.", "cid", cidstr)
 		}
 	}()
 
 	// Generate a new key
-	// we already have a random value lying around, might as
+	// we already have a random seed lying around, might as
 	// well use it for the new name.
-	keyName := base64.StdEncoding.EncodeToString(randb[:8])
-	_, err = sh.KeyGen(ctx, keyName)
+	keyName := fmt.Sprintf("%s%x", ipnsKeyPrefix, uint64(p.seed))
+	removeKey, err := newKey(ctx, node, t, localLabels, keyName, t.params.KeyType)
 	if err != nil {
 		errors.With(localLabels).Inc()
-		return fmt.Errorf("failed to generate new key: %w", err)
+		return err
 	}
-	defer func() {
-		sh.KeyRm(ctx, keyName)
-	}()
+	defer removeKey()
 
 	// Publish IPNS
 	pub_start := time.Now()
-	pubResp, err := sh.PublishWithDetails(cidstr, keyName, time.Hour, time.Hour, true)
+	pubCtx, pubSpan := tracing.Tracer.Start(ctx, "ipns_publish", trace.WithAttributes(
+		attribute.String("key_type", t.params.KeyType),
+		attribute.String("lifetime", t.params.Lifetime.String()),
+		attribute.String("ttl", t.params.TTL.String()),
+	))
+	name, err := node.Publish(pubCtx, cidstr, keyName, t.params.Lifetime, t.params.TTL)
+	tracing.End(pubSpan, &err)
+	if err != nil {
+		errors.With(localLabels).Inc()
+		return fmt.Errorf("failed to publish IPNS name: %w", err)
+	}
 	publish_time := time.Since(pub_start).Seconds()
-	log.Infow("published IPNS", "seconds", publish_time, "cid", cidstr, "ipns", pubResp.Name)
-	t.publish_time.Observe(float64(publish_time))
+	logger(ctx).Infow("published IPNS", "seconds", publish_time, "cid", cidstr, "ipns", name, "key_type", t.params.KeyType, "lifetime", t.params.Lifetime, "ttl", t.params.TTL)
+	t.publish_time.With(withVariant(t, prometheus.Labels{"size": strconv.Itoa(t.size)})).Observe(float64(publish_time))
+
+	if t.Provide() {
+		if err := provideAndDiscover(ctx, node, t, t.size, cidstr); err != nil {
+			return p.annotate(err)
+		}
+	}
 
 	// request from gateway, observing client metrics
-	url := fmt.Sprintf("%s/ipns/%s", gw, pubResp.Name)
-	return checkAndRecord(ctx, t, gw, url, randb)
+	url := fmt.Sprintf("%s/ipns/%s", gw, name)
+	return p.annotate(checkAndRecord(ctx, t, &t.prober, gw, url, p.size, p.Reader, p))
+}
+
+// newKey generates an IPNS key, recording it in the ledger. Defer the returned
+// func to remove it again. Failing to remove it is counted under errLabels.
+func newKey(ctx context.Context, node task.Node, t task.Task, errLabels prometheus.Labels, keyName string, keyType string) (func(), error) {
+	if _, err := node.KeyGen(ctx, keyName, keyType); err != nil {
+		return nil, fmt.Errorf("failed to generate new %s key: %w", keyType, err)
+	}
+	keyEntry := ledger.Entry{Kind: ledger.Key, ID: keyName}
+	track(t, keyEntry)
+	return func() {
+		// runs even when panicking. ctx may be done by now, but the key
+		// still has to go. If the process dies first, the ledger has it.
+		rmCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		rmCtx, span := tracing.Tracer.Start(rmCtx, "cleanup_key")
+		err := node.KeyRm(rmCtx, keyName)
+		tracing.End(span, &err)
+		if err != nil {
+			errors.With(errLabels).Inc()
+			logger(ctx).Warnw("failed to remove IPNS key", "key", keyName, "err", err)
+			return
+		}
+		untrack(keyEntry)
+	}, nil
 }
 
 func (t *IpnsBench) Registration() *task.Registration {
@@ -0,0 +1,30 @@
+package tasks
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIpnsBench(t *testing.T) {
+	for _, keyType := range []string{"ed25519", "rsa"} {
+		t.Run(keyType, func(t *testing.T) {
+			node, gw := newMemoryGateway(t)
+
+			bench := NewIpnsBench("* * * * *", 300*kiB)
+			bench.SetParams(IpnsParams{KeyType: keyType, Lifetime: time.Hour, TTL: time.Minute})
+			if err := bench.Run(context.Background(), node, nil, gw); err != nil {
+				t.Fatal(err)
+			}
+			if found := metricLabels(bench.publish_time, map[string]string{"key_type": keyType}); len(found) != 1 {
+				t.Errorf("expected the publish time to be recorded, got %v", found)
+			}
+			if found := metricLabels(probe_fetch, map[string]string{"test": bench.Name(), "code": "200"}); len(found) == 0 {
+				t.Error("expected the fetch to be recorded")
+			}
+			if keys := node.Keys(); len(keys) != 0 {
+				t.Errorf("keys %v left behind", keys)
+			}
+		})
+	}
+}
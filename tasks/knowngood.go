@@ -1,48 +1,51 @@
 package tasks
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 
 	"github.com/prometheus/client_golang/prometheus"
 
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
-
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
 )
 
 type KnownGoodCheck struct {
+	prober
+
 	reg        *task.Registration
 	checks     map[string][]byte
-	latency    *prometheus.HistogramVec
-	fetch_time *prometheus.HistogramVec
+	latency    prometheus.ObserverVec
+	fetch_time prometheus.ObserverVec
 }
 
 func NewKnownGoodCheck(schedule string, checks map[string][]byte) *KnownGoodCheck {
-	latency := prometheus.NewHistogramVec(
+	latency := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "known_good",
 			Name:      "latency_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 0.2, 11), // 0-2 seconds
 		},
-		defaultLabels)
+		requestLabels)
 
-	fetch_time := prometheus.NewHistogramVec(
+	fetch_time := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "known_good",
 			Name:      "fetch_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 0.2, 10), // 0-2 seconds (small file)
 		},
-		defaultLabels)
+		requestLabels)
 
 	reg := task.Registration{
 		Schedule: schedule,
 		Collectors: []prometheus.Collector{
 			latency,
 			fetch_time,
+			probe_ttfb,
+			probe_fetch,
 		},
 	}
 	return &KnownGoodCheck{
@@ -57,19 +60,20 @@ func (t *KnownGoodCheck) Name() string {
 	return "known_good"
 }
 
-func (t *KnownGoodCheck) LatencyHist() *prometheus.HistogramVec {
+func (t *KnownGoodCheck) LatencyHist() prometheus.ObserverVec {
 	return t.latency
 }
 
-func (t *KnownGoodCheck) FetchHist() *prometheus.HistogramVec {
+func (t *KnownGoodCheck) FetchHist() prometheus.ObserverVec {
 	return t.fetch_time
 }
 
-func (t *KnownGoodCheck) Run(ctx context.Context, sh *shell.Shell, ps *pinning.Client, gw string) error {
+func (t *KnownGoodCheck) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
 	for ipfspath, value := range t.checks {
 		// request from gateway, observing client metrics
 		url := fmt.Sprintf("%s%s", gw, ipfspath)
-		err := checkAndRecord(ctx, t, gw, url, value)
+		expected := func() io.Reader { return bytes.NewReader(value) }
+		err := checkAndRecord(ctx, t, &t.prober, gw, url, len(value), expected, nil)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,86 @@
+package tasks
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// publisher holds the layout a task adds its content to IPFS with, and whether
+// it provides the content before fetching it. The own metrics of tasks that
+// embed it are labelled with their layout.
+type publisher struct {
+	layout  task.Layout
+	provide bool
+}
+
+// SetProvide makes this task provide its content and wait for it to be
+// discoverable before fetching it, regardless of ProvideBeforeFetch.
+func (p *publisher) SetProvide(provide bool) {
+	p.provide = provide
+}
+
+func (p *publisher) Provide() bool {
+	return p.provide || ProvideBeforeFetch
+}
+
+// SetLayout picks the DAG layout for this task.
+func (p *publisher) SetLayout(l task.Layout) {
+	p.layout = l
+}
+
+func (p *publisher) Layout() task.Layout {
+	return p.layout
+}
+
+// layoutOf returns the layout t adds content with, the default unless it
+// embeds a publisher.
+func layoutOf(t task.Task) task.Layout {
+	if p, ok := t.(interface{ Layout() task.Layout }); ok {
+		return p.Layout()
+	}
+	return task.Layout{}
+}
+
+func (p *publisher) variantLabels() prometheus.Labels {
+	return prometheus.Labels{"dag": p.layout.String()}
+}
+
+// layoutMatrix returns every combination of the given parameters that makes
+// sense. CIDv0 only comes as sha2-256 without raw leaves, so other hash
+// functions and raw leaves are only combined with CIDv1.
+func layoutMatrix(cidVersions []int, hashes []string, chunkers []string, rawLeaves []bool) []task.Layout {
+	var layouts []task.Layout
+	for _, v := range cidVersions {
+		for _, h := range hashes {
+			for _, c := range chunkers {
+				for _, raw := range rawLeaves {
+					if v == 0 && (raw || (h != "" && h != "sha2-256")) {
+						continue
+					}
+					layouts = append(layouts, task.Layout{
+						CidVersion: v,
+						Hash:       h,
+						Chunker:    c,
+						RawLeaves:  raw,
+					})
+				}
+			}
+		}
+	}
+	return layouts
+}
+
+// withLayouts creates one task per layout, passing newTask its index.
+func withLayouts(layouts []task.Layout, newTask func(i int) interface {
+	task.Task
+	SetLayout(task.Layout)
+}) []task.Task {
+	tasks := make([]task.Task, 0, len(layouts))
+	for i, l := range layouts {
+		t := newTask(i)
+		t.SetLayout(l)
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
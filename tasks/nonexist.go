@@ -2,7 +2,6 @@ package tasks
 
 import (
 	"context"
-	"crypto/rand"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,45 +10,49 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 
-	"github.com/ipfs/go-cid"
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
-	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/resultlog"
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
 )
 
 type NonExistCheck struct {
+	prober
+
 	reg        *task.Registration
-	latency    *prometheus.HistogramVec
-	fetch_time *prometheus.HistogramVec
+	latency    prometheus.ObserverVec
+	fetch_time prometheus.ObserverVec
 	errors     *prometheus.CounterVec
 }
 
 func NewNonExistCheck(schedule string) *NonExistCheck {
-	start_time := prometheus.NewHistogramVec(
+	start_time := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "non_exist",
 			Name:      "latency_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 30, 20), // 0-10-minutes
 		},
-		defaultLabels)
+		requestLabels)
 
-	fetch_time := prometheus.NewHistogramVec(
+	fetch_time := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "non_exist",
 			Name:      "fetch_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 0.2, 10), // 0-1 second. This should never happen in reality.
 		},
-		defaultLabels)
+		requestLabels)
 
 	reg := task.Registration{
 		Schedule: schedule,
 		Collectors: []prometheus.Collector{
 			start_time,
 			fetch_time,
+			probe_ttfb,
+			probe_fetch,
 			fails,
 			errors,
 		},
@@ -66,62 +69,91 @@ func (t *NonExistCheck) Name() string {
 	return "non_exist"
 }
 
-func (t *NonExistCheck) LatencyHist() *prometheus.HistogramVec {
+func (t *NonExistCheck) LatencyHist() prometheus.ObserverVec {
 	return t.latency
 }
 
-func (t *NonExistCheck) FetchHist() *prometheus.HistogramVec {
+func (t *NonExistCheck) FetchHist() prometheus.ObserverVec {
 	return t.fetch_time
 }
 
-func (t *NonExistCheck) Run(ctx context.Context, sh *shell.Shell, ps *pinning.Client, gw string) error {
+func (t *NonExistCheck) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
 	localLabels := task.Labels(t, "localhost", 0, 0)
-	gwLabels := task.Labels(t, gw, 0, 0)
 
-	buf := make([]byte, 128)
-	_, err := rand.Read(buf)
+	c, err := randomCID()
 	if err != nil {
 		t.errors.With(localLabels).Inc()
-		return fmt.Errorf("failed to generate random bytes: %w", err)
+		return err
 	}
+	logger(ctx).Infof("generated random CID %s", c)
 
-	encoded, err := multihash.EncodeName(buf, "sha3")
-	if err != nil {
-		t.errors.With(localLabels).Inc()
-		return fmt.Errorf("failed to generate multihash of random bytes: %w", err)
-	}
-	cast, err := multihash.Cast(encoded)
-	if err != nil {
-		t.errors.With(localLabels).Inc()
-		return fmt.Errorf("failed to cast as multihash: %w", err)
+	url := fmt.Sprintf("%s/ipfs/%s", gw, c)
+	for _, conn := range t.conns() {
+		err := t.probe(ctx, conn, gw, url)
+		if conn.fresh {
+			conn.client.CloseIdleConnections()
+		}
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	c := cid.NewCidV1(cid.Raw, cast)
-	log.Infof("generated random CID %s", c)
+func (t *NonExistCheck) probe(ctx context.Context, conn probeConn, gw string, url string) (err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "gateway_request", trace.WithAttributes(
+		attribute.String("url", url),
+		attribute.String("conn", string(conn.mode)),
+	))
+	defer tracing.End(span, &err)
+	l := logger(ctx)
 
-	url := fmt.Sprintf("%s/ipfs/%s", gw, c)
+	gwLabels := task.Labels(t, gw, 0, 0)
 
-	log.Infow("fetching from gateway", "url", url)
+	l.Infow("fetching from gateway", "url", url, "conn", conn.mode)
 	req, _ := http.NewRequest("GET", url, nil)
 	start := time.Now()
 	var firstByteTime time.Time
-	trace := &httptrace.ClientTrace{
-		GotFirstResponseByte: func() {
-			latency := time.Since(start).Seconds()
-			log.Infow("first byte received", "seconds", latency)
-			firstByteTime = time.Now()
-		},
-	}
-	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
-	resp, err := http.DefaultClient.Do(req)
+	result := newResult(t, gw, url, gwLabels)
+	result.Conn = string(conn.mode)
+	defer func() {
+		if !firstByteTime.IsZero() {
+			result.TTFB = firstByteTime.Sub(start).Seconds()
+		}
+		result.Total = time.Since(start).Seconds()
+		recordResult(ctx, result, err)
+	}()
+	phases := newPhaseTrace(func() {
+		latency := time.Since(start).Seconds()
+		l.Infow("first byte received", "seconds", latency)
+		firstByteTime = time.Now()
+	})
+	req = req.WithContext(httptrace.WithClientTrace(ctx, phases.ClientTrace()))
+	tracing.Inject(ctx, req.Header)
+	requestID := tagRequest(req)
+	result.RequestID = requestID
+	span.SetAttributes(attribute.String("request_id", requestID))
+	l = l.With("request_id", requestID)
+	ex := exemplar(ctx, requestID, nil)
+
+	resp, err := conn.client.Do(req)
 	if err != nil {
-		t.errors.With(gwLabels).Inc()
-		return fmt.Errorf("failed to fetch from gateway: %w", err)
+		inc(t.errors, gwLabels, ex)
+		return fmt.Errorf("failed to fetch from gateway: %w. request id: %s", err, requestID)
+	}
+	defer resp.Body.Close()
+	result.ResponseIDs = responseIDs(resp)
+	ex = exemplar(ctx, requestID, result.ResponseIDs)
+	if len(result.ResponseIDs) > 0 {
+		l = l.With("response_ids", result.ResponseIDs)
 	}
-	_, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
+	result.Bytes = int64(len(body))
+	phases.BodyDone()
+	phases.AddEvents(span)
 	if err != nil {
-		t.errors.With(gwLabels).Inc()
-		return fmt.Errorf("failed to download content: %w", err)
+		inc(t.errors, gwLabels, ex)
+		return fmt.Errorf("failed to download content: %w. request id: %s", err, requestID)
 	}
 
 	pop := resp.Header.Get("X-IPFS-POP")
@@ -129,23 +161,37 @@ func (t *NonExistCheck) Run(ctx context.Context, sh *shell.Shell, ps *pinning.Cl
 		pop = resp.Header.Get("X-IPFS-LB-POP") // If go-ipfs didn't reply, get the pop from the LB
 	}
 
-	log.Info("checking that we got a 404 or 504")
+	result.Pop, result.Status, result.Proto = pop, resp.StatusCode, resp.Proto
+	span.SetAttributes(
+		attribute.Int("status", resp.StatusCode),
+		attribute.String("pop", pop),
+		attribute.String("proto", resp.Proto),
+	)
+	l.Info("checking that we got a 404 or 504")
 	responseLabels := task.Labels(t, pop, 0, resp.StatusCode)
+	reqLabels := withConn(responseLabels, conn.mode, resp.Proto)
 
 	if resp.StatusCode != 404 && resp.StatusCode != 504 {
-		fails.With(responseLabels).Inc()
-		return fmt.Errorf("expected to see 404 or 504 from gateway, but didn't. pop: %s, status: (%d)", pop, resp.StatusCode)
+		inc(fails, responseLabels, ex)
+		result.Outcome = resultlog.Fail
+		return fmt.Errorf("expected to see 404 or 504 from gateway, but didn't. pop: %s, status: (%d), request id: %s", pop, resp.StatusCode, requestID)
 	}
 
 	// Record observations.
 	timeToFirstByte := firstByteTime.Sub(start).Seconds()
 	totalTime := time.Since(start).Seconds()
 
-	t.latency.With(responseLabels).Observe(float64(timeToFirstByte))
-	fetch_latency.With(responseLabels).Set(float64(timeToFirstByte))
+	observe(probe_ttfb, reqLabels, timeToFirstByte, ex)
+	observe(probe_fetch, reqLabels, totalTime, ex)
+	if LegacyHistograms {
+		t.latency.With(reqLabels).Observe(float64(timeToFirstByte))
+	}
+	fetch_latency.With(reqLabels).Set(float64(timeToFirstByte))
 
-	log.Infow("finished download", "seconds", totalTime, "pop", pop)
-	t.fetch_time.With(responseLabels).Observe(float64(totalTime))
+	l.Infow("finished download", "seconds", totalTime, "pop", pop)
+	if LegacyHistograms {
+		t.fetch_time.With(reqLabels).Observe(float64(totalTime))
+	}
 
 	return nil
 }
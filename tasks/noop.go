@@ -7,9 +7,6 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
-
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
 )
 
@@ -19,7 +16,7 @@ type NoopTask struct {
 	g        prometheus.Gauge
 }
 
-func (t *NoopTask) Run(ctx context.Context, sh *shell.Shell, ps *pinning.Client, gw string) error {
+func (t *NoopTask) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
 	for i := 0; i < t.i; i++ {
 		time.Sleep(time.Second)
 		fmt.Println("test")
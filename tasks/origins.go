@@ -0,0 +1,121 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// OriginsMode controls whether pin requests tell the pinning service where the
+// content can be found. Without origins the service has to find our node
+// through the DHT, which makes time-to-pinned mostly a routing benchmark.
+type OriginsMode string
+
+const (
+	// OriginsDefault defers to DefaultOrigins.
+	OriginsDefault OriginsMode = ""
+	// OriginsOff never passes origins.
+	OriginsOff OriginsMode = "off"
+	// OriginsOn always passes the local node's addresses as origins.
+	OriginsOn OriginsMode = "on"
+	// OriginsAlternate passes origins on every other run, so both series are
+	// recorded by the same task.
+	OriginsAlternate OriginsMode = "alternate"
+)
+
+// DefaultOrigins is used by tasks that don't pick an origins mode of their own.
+var DefaultOrigins = OriginsAlternate
+
+func ParseOriginsMode(s string) (OriginsMode, error) {
+	switch m := OriginsMode(s); m {
+	case OriginsOff, OriginsOn, OriginsAlternate:
+		return m, nil
+	}
+	return OriginsDefault, fmt.Errorf("unknown origins mode %q. expected off, on or alternate", s)
+}
+
+// originsPicker decides, run by run, whether to pass origins.
+type originsPicker struct {
+	mode OriginsMode
+	runs uint64
+}
+
+func (o *originsPicker) next() bool {
+	mode := o.mode
+	if mode == OriginsDefault {
+		mode = DefaultOrigins
+	}
+	switch mode {
+	case OriginsOn:
+		return true
+	case OriginsAlternate:
+		return atomic.AddUint64(&o.runs, 1)%2 == 1
+	}
+	return false
+}
+
+var (
+	delegate_connect = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pinning",
+			Name:      "delegate_connect_seconds",
+			Help:      "time for the local node to connect to a delegate returned by the pinning service",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10), // 50ms-25 seconds
+		},
+		[]string{"pinning_service"})
+	delegate_connect_errors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pinning",
+			Name:      "delegate_connect_error_count",
+		},
+		[]string{"pinning_service"})
+)
+
+// localOrigins returns the addresses of the local node that a pinning service
+// could possibly dial: everything but loopback and private network addresses.
+func localOrigins(ctx context.Context, node task.Node) ([]multiaddr.Multiaddr, error) {
+	id, err := node.ID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local node addresses: %w", err)
+	}
+
+	var origins []multiaddr.Multiaddr
+	for _, a := range id.Addresses {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			logger(ctx).Warnw("local node has an invalid address", "addr", a, "err", err)
+			continue
+		}
+		if manet.IsIPLoopback(ma) || manet.IsPrivateAddr(ma) {
+			continue
+		}
+		origins = append(origins, ma)
+	}
+	return origins, nil
+}
+
+// connectDelegates connects the local node to each of the delegates a pinning
+// service returned, timing every connection.
+func connectDelegates(ctx context.Context, node task.Node, service string, delegates []multiaddr.Multiaddr) {
+	for _, d := range delegates {
+		start := time.Now()
+		err := node.Connect(ctx, d.String())
+		if err != nil {
+			delegate_connect_errors.WithLabelValues(service).Inc()
+			logger(ctx).Warnw("failed to connect to delegate", "service", service, "delegate", d, "err", err)
+			continue
+		}
+		delegate_connect.WithLabelValues(service).Observe(time.Since(start).Seconds())
+		logger(ctx).Infow("connected to delegate", "service", service, "delegate", d, "seconds", time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,60 @@
+package tasks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipfs"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// addrNode is a MemoryNode that reports the given addresses.
+type addrNode struct {
+	*ipfs.MemoryNode
+	addrs []string
+}
+
+func (n addrNode) ID(ctx context.Context) (task.NodeID, error) {
+	return task.NodeID{ID: "peer", Addresses: n.addrs}, nil
+}
+
+func TestLocalOrigins(t *testing.T) {
+	node := addrNode{ipfs.NewMemoryNode(), []string{
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip6/::1/tcp/4001",
+		"/ip4/10.1.2.3/tcp/4001",
+		"/ip4/172.16.0.5/udp/4001/quic-v1",
+		"/ip4/192.168.1.10/tcp/4001",
+		"/ip4/203.0.114.7/tcp/4001",
+		"/dns4/node.example.com/tcp/4001",
+	}}
+	origins, err := localOrigins(context.Background(), node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range origins {
+		got = append(got, o.String())
+	}
+	want := []string{"/ip4/203.0.114.7/tcp/4001", "/dns4/node.example.com/tcp/4001"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("origins are %v, expected %v", got, want)
+	}
+}
+
+func TestOriginsDefault(t *testing.T) {
+	defer func(m OriginsMode) { DefaultOrigins = m }(DefaultOrigins)
+
+	var o originsPicker
+	DefaultOrigins = OriginsOn
+	if !o.next() || !o.next() {
+		t.Error("expected origins on every run")
+	}
+	DefaultOrigins = OriginsOff
+	if o.next() {
+		t.Error("expected no origins")
+	}
+	if _, err := ParseOriginsMode("sometimes"); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
@@ -0,0 +1,144 @@
+package tasks
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/resultlog"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// Large benches move hundreds of MiB through the gateway. Rather than holding
+// that content in memory, a payload only keeps the seed it was generated from
+// and regenerates the bytes whenever they are needed: once to add them to IPFS,
+// and once more to verify the gateway response as it streams in.
+//
+// The seed is logged, included in errors and written to the probe results, so
+// the exact content of a failed run can be regenerated later with
+// PayloadReader, or the payload command.
+
+const verifyChunkSize = 32 * kiB
+
+type payload struct {
+	seed int64
+	size int
+}
+
+// newRandomPayload picks a random seed for a payload of the given size.
+func newRandomPayload(size int) (*payload, error) {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	return &payload{
+		seed: int64(binary.LittleEndian.Uint64(b[:])),
+		size: size,
+	}, nil
+}
+
+// Reader returns a fresh stream of the payload content.
+func (p *payload) Reader() io.Reader {
+	return PayloadReader(p.seed, p.size)
+}
+
+// annotate adds the seed to the error of a failed run.
+func (p *payload) annotate(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w. payload seed: %d, size: %d", err, p.seed, p.size)
+}
+
+// result describes the payload, added to IPFS with l, for the results log.
+func (p *payload) result(l task.Layout) *resultlog.Payload {
+	return &resultlog.Payload{
+		Seed:       p.seed,
+		Size:       p.size,
+		Dag:        l.String(),
+		CidVersion: l.CidVersion,
+		Hash:       l.Hash,
+		Chunker:    l.Chunker,
+		RawLeaves:  l.RawLeaves,
+	}
+}
+
+// PayloadReader returns the content of the payload generated from seed. The
+// math/rand sequence for a given seed is stable across Go releases, so this is
+// the same content a task published, however long ago.
+func PayloadReader(seed int64, size int) io.Reader {
+	return io.LimitReader(rand.New(rand.NewSource(seed)), int64(size))
+}
+
+// verifier is an io.Writer that compares everything written to it against an
+// expected stream, remembering the offset of the first byte that differs.
+type verifier struct {
+	expected io.Reader
+	buf      []byte
+	offset   int64
+	mismatch int64
+}
+
+func newVerifier(expected io.Reader) *verifier {
+	return &verifier{
+		expected: expected,
+		buf:      make([]byte, verifyChunkSize),
+		mismatch: -1,
+	}
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && v.mismatch < 0 {
+		chunk := p
+		if len(chunk) > len(v.buf) {
+			chunk = chunk[:len(v.buf)]
+		}
+		got, err := io.ReadFull(v.expected, v.buf[:len(chunk)])
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+		if i := firstDiff(chunk[:got], v.buf[:got]); i >= 0 {
+			v.mismatch = v.offset + int64(i)
+		} else if got < len(chunk) {
+			// response is longer than the expected content
+			v.mismatch = v.offset + int64(got)
+		}
+		v.offset += int64(got)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Verify reports an error with the first mismatching offset, if any. It must be
+// called once the whole response has been written.
+func (v *verifier) Verify() error {
+	if v.mismatch < 0 {
+		// response may have been shorter than the expected content
+		n, err := v.expected.Read(v.buf[:1])
+		if n > 0 {
+			v.mismatch = v.offset
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if v.mismatch >= 0 {
+		return fmt.Errorf("content differs from expected at byte offset %d", v.mismatch)
+	}
+	return nil
+}
+
+func firstDiff(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return len(a)
+}
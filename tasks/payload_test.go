@@ -0,0 +1,73 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipfs"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+func TestVerifier(t *testing.T) {
+	expected, err := ioutil.ReadAll(PayloadReader(1, 100*kiB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := append([]byte(nil), expected...)
+	flipped[70*kiB] ^= 0xff
+
+	cases := []struct {
+		name     string
+		response []byte
+		mismatch int64
+	}{
+		{"same", expected, -1},
+		{"flipped", flipped, 70 * kiB},
+		{"short", expected[:50*kiB], 50 * kiB},
+		{"long", append(append([]byte(nil), expected...), 'x'), 100 * kiB},
+		{"empty", nil, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := newVerifier(PayloadReader(1, 100*kiB))
+			// odd sized writes, so they don't line up with the chunks
+			if _, err := io.CopyBuffer(v, bytes.NewReader(c.response), make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+			err := v.Verify()
+			if c.mismatch < 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected the content to differ")
+			}
+			if v.mismatch != c.mismatch {
+				t.Errorf("mismatch at %d, expected %d", v.mismatch, c.mismatch)
+			}
+		})
+	}
+}
+
+func TestPayloadReproducible(t *testing.T) {
+	node := ipfs.NewMemoryNode()
+	add := func(seed int64) string {
+		c, err := node.Add(context.Background(), PayloadReader(seed, 300*kiB), task.Layout{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	first, again, other := add(42), add(42), add(43)
+	if first != again {
+		t.Errorf("same seed gave %s and %s", first, again)
+	}
+	if first == other {
+		t.Errorf("different seeds both gave %s", first)
+	}
+}
@@ -0,0 +1,339 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ipfs/go-cid"
+	pinning "github.com/ipfs/go-pinning-service-http-client"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ledger"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// PinningApiCheck walks a pin through its whole lifecycle on the pinning
+// service, exercising every endpoint of the Pinning Service API rather than
+// only add and get.
+type PinningApiCheck struct {
+	reg     *task.Registration
+	latency prometheus.ObserverVec
+	errors  *prometheus.CounterVec
+}
+
+func NewPinningApiCheck(schedule string) *PinningApiCheck {
+	latency := sharedHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pinning_api",
+			Name:      "call_seconds",
+			Buckets:   prometheus.LinearBuckets(0, 0.5, 11), // 0-5 seconds
+		},
+		[]string{"pinning_service", "call"})
+
+	errors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pinning_api",
+			Name:      "call_error_count",
+		},
+		[]string{"pinning_service", "call"})
+
+	reg := task.Registration{
+		Schedule: schedule,
+		Collectors: []prometheus.Collector{
+			latency,
+			errors,
+		},
+	}
+	return &PinningApiCheck{
+		reg:     &reg,
+		latency: latency,
+		errors:  errors,
+	}
+}
+
+func (t *PinningApiCheck) Name() string {
+	return "pinning_api"
+}
+
+func (t *PinningApiCheck) LatencyHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *PinningApiCheck) FetchHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *PinningApiCheck) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	if len(ps) == 0 {
+		logger(ctx).Infof("%s: no pinning service configured, skipping", t.Name())
+		return nil
+	}
+
+	var failed error
+	for _, svc := range ps {
+		if err := t.check(ctx, svc); err != nil {
+			logger(ctx).Errorw("pinning service failed conformance check", "service", svc.Name, "err", err)
+			failed = err
+		}
+	}
+	return failed
+}
+
+func (t *PinningApiCheck) check(ctx context.Context, ps task.PinningService) error {
+	service := ps.Name
+	pinLabels := task.Labels(t, "pinning", 0, 0)
+	fail := func(format string, args ...interface{}) error {
+		pinFail(pinLabels, service)
+		return fmt.Errorf("%s: %s: "+format, append([]interface{}{t.Name(), service}, args...)...)
+	}
+
+	var cids [3]cid.Cid
+	for i := range cids {
+		c, err := randomCID()
+		if err != nil {
+			errors.With(task.Labels(t, "localhost", 0, 0)).Inc()
+			return err
+		}
+		cids[i] = c
+	}
+
+	name := fmt.Sprintf("%s%s-%d", monitorPinPrefix, t.Name(), time.Now().UnixNano())
+	meta := map[string]string{
+		monitorPinMetaKey: monitorPinMetaVal,
+		"task":            t.Name(),
+		"run":             name,
+	}
+
+	// whatever happens, don't leave our pins behind
+	live := make(map[string]bool)
+	setLive := func(id string, isLive bool) {
+		e := ledger.Entry{Kind: ledger.RemotePin, ID: id, Service: service}
+		if isLive {
+			live[id] = true
+			track(t, e)
+		} else {
+			delete(live, id)
+			untrack(e)
+		}
+	}
+	defer func() {
+		// ctx may be done by now, but the pins still have to go. If the
+		// process dies first, the ledger has them.
+		delCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		for id := range live {
+			if err := ps.DeleteByID(delCtx, id); err != nil {
+				logger(ctx).Warnw("failed to clean up pin", "service", service, "requestid", id, "err", err)
+				continue
+			}
+			setLive(id, false)
+		}
+	}()
+
+	// add two pins under the same name, so there is something to paginate
+	var added [2]pinning.PinStatusGetter
+	for i := range added {
+		if i > 0 {
+			// the client pages with second precision timestamps. Make sure
+			// the pins aren't created within the same second.
+			wait := time.Until(added[i-1].GetCreated().Truncate(time.Second).Add(time.Second))
+			if wait > time.Second {
+				// the service's clock is off, don't trust it
+				wait = time.Second
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err := t.call(service, "add", func() (err error) {
+			added[i], err = ps.Add(ctx, cids[i], pinning.PinOpts.WithName(name), pinning.PinOpts.AddMeta(meta))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		setLive(added[i].GetRequestId(), true)
+		if !added[i].GetPin().GetCid().Equals(cids[i]) {
+			return fail("add returned cid %s, expected %s", added[i].GetPin().GetCid(), cids[i])
+		}
+	}
+
+	var got pinning.PinStatusGetter
+	err := t.call(service, "get", func() (err error) {
+		got, err = ps.GetStatusByID(ctx, added[0].GetRequestId())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if got.GetPin().GetName() != name {
+		return fail("get returned name %q, expected %q", got.GetPin().GetName(), name)
+	}
+	if got.GetPin().GetMeta()["run"] != name {
+		return fail("get returned meta %v, expected %v", got.GetPin().GetMeta(), meta)
+	}
+
+	// filters
+	checkLs := func(call string, want int, opts ...pinning.LsOption) error {
+		var res []pinning.PinStatusGetter
+		err := t.call(service, call, func() (err error) {
+			res, err = ps.LsSync(ctx, opts...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(res) != want {
+			return fail("%s returned %d pins, expected %d", call, len(res), want)
+		}
+		return nil
+	}
+	allStatuses := pinning.PinOpts.FilterStatus(pinning.StatusQueued, pinning.StatusPinning, pinning.StatusPinned, pinning.StatusFailed)
+	if err := checkLs("ls_name", 2, pinning.PinOpts.FilterName(name), allStatuses); err != nil {
+		return err
+	}
+	// the client sends meta formatted with %v rather than as JSON, so this
+	// one is requested by hand
+	var byMeta int
+	err = t.call(service, "ls_meta", func() (err error) {
+		byMeta, err = lsByMeta(ctx, ps, meta)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if byMeta != 2 {
+		return fail("ls_meta returned %d pins, expected 2", byMeta)
+	}
+	if err := checkLs("ls_cid", 1, pinning.PinOpts.FilterCIDs(cids[1]), allStatuses); err != nil {
+		return err
+	}
+
+	// pagination: one pin per page, but the count covers both
+	var page []pinning.PinStatusGetter
+	var count int
+	err = t.call(service, "ls_page", func() (err error) {
+		page, count, err = ps.LsBatchSync(ctx, pinning.PinOpts.FilterName(name), allStatuses, pinning.PinOpts.Limit(1))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(page) != 1 || count != 2 {
+		return fail("ls with limit 1 returned %d pins and count %d, expected 1 and 2", len(page), count)
+	}
+	// LsSync follows the pages on its own
+	if err := checkLs("ls_paginate", 2, pinning.PinOpts.FilterName(name), allStatuses, pinning.PinOpts.Limit(1)); err != nil {
+		return err
+	}
+
+	// replace
+	var replaced pinning.PinStatusGetter
+	err = t.call(service, "replace", func() (err error) {
+		replaced, err = ps.Replace(ctx, added[0].GetRequestId(), cids[2], pinning.PinOpts.WithName(name), pinning.PinOpts.AddMeta(meta))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	setLive(added[0].GetRequestId(), false)
+	setLive(replaced.GetRequestId(), true)
+	if !replaced.GetPin().GetCid().Equals(cids[2]) {
+		return fail("replace returned cid %s, expected %s", replaced.GetPin().GetCid(), cids[2])
+	}
+	if err := checkLs("ls_replaced", 0, pinning.PinOpts.FilterCIDs(cids[0]), allStatuses); err != nil {
+		return err
+	}
+
+	// delete, then make sure it's gone
+	for id := range live {
+		err := t.call(service, "delete", func() error {
+			return ps.DeleteByID(ctx, id)
+		})
+		if err != nil {
+			return err
+		}
+		setLive(id, false)
+
+		var found bool
+		err = t.call(service, "get_deleted", func() error {
+			_, err := ps.GetStatusByID(ctx, id)
+			if pinningStatus(err) == http.StatusNotFound {
+				return nil
+			}
+			found = err == nil
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if found {
+			return fail("pin %s can still be fetched after it was deleted", id)
+		}
+	}
+	return checkLs("ls_deleted", 0, pinning.PinOpts.FilterName(name), allStatuses)
+}
+
+// lsByMeta lists the pins in any status that carry all of meta, and returns
+// how many there are.
+func lsByMeta(ctx context.Context, ps task.PinningService, meta map[string]string) (int, error) {
+	filter, err := json.Marshal(meta)
+	if err != nil {
+		return 0, err
+	}
+	q := url.Values{}
+	q.Set("meta", string(filter))
+	q.Set("status", strings.Join([]string{
+		string(pinning.StatusQueued),
+		string(pinning.StatusPinning),
+		string(pinning.StatusPinned),
+		string(pinning.StatusFailed),
+	}, ","))
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(ps.URL, "/")+"/pins?"+q.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+ps.Token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("listing pins by meta: %s", resp.Status)
+	}
+	var res struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, fmt.Errorf("listing pins by meta: %w", err)
+	}
+	return len(res.Results), nil
+}
+
+// call times a single API call, counting it as an error if it fails.
+func (t *PinningApiCheck) call(service string, name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	t.latency.WithLabelValues(service, name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		t.errors.WithLabelValues(service, name).Inc()
+		return fmt.Errorf("%s: %s: %s failed: %w", t.Name(), service, name, err)
+	}
+	return nil
+}
+
+func (t *PinningApiCheck) Registration() *task.Registration {
+	return t.reg
+}
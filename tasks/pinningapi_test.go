@@ -0,0 +1,63 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/pinningtest"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+func TestPinningApiCheck(t *testing.T) {
+	srv, ps := newPinningService(t, "api-ok")
+	// the check doesn't wait for pins, so they can stay queued
+	srv.SetBehaviour(pinningtest.Behaviour{QueuedFor: time.Hour})
+
+	check := NewPinningApiCheck("* * * * *")
+	if err := check.Run(context.Background(), nil, task.PinningServices{ps}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if pins := srv.Pins(); len(pins) != 0 {
+		t.Errorf("%d pins left behind on the pinning service", len(pins))
+	}
+	for _, call := range []string{"add", "get", "ls_name", "ls_meta", "ls_cid", "ls_page", "ls_paginate", "replace", "ls_replaced", "delete", "get_deleted", "ls_deleted"} {
+		if n := testutil.ToFloat64(check.errors.WithLabelValues(ps.Name, call)); n != 0 {
+			t.Errorf("%s failed %v times", call, n)
+		}
+	}
+	if n := len(metricLabels(check.latency, prometheus.Labels{"pinning_service": ps.Name})); n != 12 {
+		t.Errorf("timed %d calls, expected 12", n)
+	}
+}
+
+func TestPinningApiCheckFailures(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(*pinningtest.Server)
+	}{
+		{"unavailable", func(s *pinningtest.Server) { s.FailRequests(1, http.StatusInternalServerError) }},
+		{"unauthorized", func(s *pinningtest.Server) { s.RejectAuth(true) }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv, ps := newPinningService(t, "api-"+c.name)
+			c.setup(srv)
+
+			check := NewPinningApiCheck("* * * * *")
+			if err := check.Run(context.Background(), nil, task.PinningServices{ps}, ""); err == nil {
+				t.Fatal("expected the check to fail")
+			}
+			if n := testutil.ToFloat64(check.errors.WithLabelValues(ps.Name, "add")); n != 1 {
+				t.Errorf("add failed %v times, expected once", n)
+			}
+			if pins := srv.Pins(); len(pins) != 0 {
+				t.Errorf("%d pins left behind on the pinning service", len(pins))
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pinning "github.com/ipfs/go-pinning-service-http-client"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// Every pin the monitor asks a pinning service for is tagged, so pins left
+// behind by a crashed run can be told apart from anything else on the account.
+const (
+	monitorPinPrefix  = "gateway-monitor-"
+	monitorPinMetaKey = "created-by"
+	monitorPinMetaVal = "gateway-monitor"
+)
+
+// monitorPinOpts names and tags a pin created by the given task.
+func monitorPinOpts(taskName string, meta map[string]string) []pinning.AddOption {
+	tagged := map[string]string{
+		monitorPinMetaKey: monitorPinMetaVal,
+		"task":            taskName,
+	}
+	for k, v := range meta {
+		tagged[k] = v
+	}
+	name := fmt.Sprintf("%s%s-%d", monitorPinPrefix, taskName, time.Now().UnixNano())
+	return []pinning.AddOption{
+		pinning.PinOpts.WithName(name),
+		pinning.PinOpts.AddMeta(tagged),
+	}
+}
+
+// PinSweeper removes monitor-created pins that are older than a threshold.
+// Tasks delete their own pins, so anything it finds was orphaned by a run that
+// didn't get to clean up.
+type PinSweeper struct {
+	reg       *task.Registration
+	olderThan time.Duration
+	swept     *prometheus.CounterVec
+	orphans   *prometheus.GaugeVec
+}
+
+func NewPinSweeper(schedule string, olderThan time.Duration) *PinSweeper {
+	swept := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pin_sweeper",
+			Name:      "swept_count",
+		},
+		[]string{"pinning_service"})
+
+	orphans := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pin_sweeper",
+			Name:      "orphans",
+			Help:      "monitor-created pins older than the threshold found on the last sweep",
+		},
+		[]string{"pinning_service"})
+
+	reg := task.Registration{
+		Schedule: schedule,
+		Collectors: []prometheus.Collector{
+			swept,
+			orphans,
+		},
+	}
+	return &PinSweeper{
+		reg:       &reg,
+		olderThan: olderThan,
+		swept:     swept,
+		orphans:   orphans,
+	}
+}
+
+func (t *PinSweeper) Name() string {
+	return "pin_sweeper"
+}
+
+func (t *PinSweeper) LatencyHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *PinSweeper) FetchHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *PinSweeper) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	if len(ps) == 0 {
+		logger(ctx).Infof("%s: no pinning service configured, skipping", t.Name())
+		return nil
+	}
+
+	var failed error
+	for _, svc := range ps {
+		if err := t.sweep(ctx, svc); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+func (t *PinSweeper) sweep(ctx context.Context, ps task.PinningService) error {
+	service := ps.Name
+	pinLabels := task.Labels(t, "pinning", 0, 0)
+
+	// the client can't send a meta filter the way the spec asks for it, so
+	// list everything old enough and pick out our own pins here
+	pins, err := ps.LsSync(ctx,
+		pinning.PinOpts.FilterBefore(time.Now().Add(-t.olderThan)),
+		pinning.PinOpts.FilterStatus(pinning.StatusQueued, pinning.StatusPinning, pinning.StatusPinned, pinning.StatusFailed),
+	)
+	if err != nil {
+		pinError(pinLabels, service)
+		return fmt.Errorf("%s: failed to list pins on %s: %w", t.Name(), service, err)
+	}
+
+	var orphans int
+	var failed error
+	for _, p := range pins {
+		if p.GetPin().GetMeta()[monitorPinMetaKey] != monitorPinMetaVal || !strings.HasPrefix(p.GetPin().GetName(), monitorPinPrefix) {
+			continue
+		}
+		orphans++
+		logger(ctx).Infow("deleting orphaned pin", "service", service, "requestid", p.GetRequestId(), "name", p.GetPin().GetName(), "created", p.GetCreated())
+		if err := ps.DeleteByID(ctx, p.GetRequestId()); err != nil {
+			pinError(pinLabels, service)
+			failed = fmt.Errorf("%s: failed to delete pin %s on %s: %w", t.Name(), p.GetRequestId(), service, err)
+			continue
+		}
+		t.swept.WithLabelValues(service).Inc()
+	}
+	t.orphans.WithLabelValues(service).Set(float64(orphans))
+	logger(ctx).Infof("%s: found %d orphaned pins on %s", t.Name(), orphans, service)
+	return failed
+}
+
+func (t *PinSweeper) Registration() *task.Registration {
+	return t.reg
+}
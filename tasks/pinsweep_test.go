@@ -0,0 +1,85 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	pinning "github.com/ipfs/go-pinning-service-http-client"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/pinningtest"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// addPins adds a pin orphaned by the monitor, and two that only look like
+// they might be: one named like the monitor's, and one tagged like them.
+func addPins(t *testing.T, ps task.PinningService) {
+	opts := [][]pinning.AddOption{
+		monitorPinOpts("orphaned", nil),
+		{pinning.PinOpts.WithName(monitorPinPrefix + "lookalike")},
+		{pinning.PinOpts.WithName("mine"), pinning.PinOpts.AddMeta(map[string]string{monitorPinMetaKey: monitorPinMetaVal})},
+	}
+	for _, o := range opts {
+		c, err := randomCID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ps.Add(context.Background(), c, o...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPinSweeper(t *testing.T) {
+	srv, ps := newPinningService(t, "sweep-ok")
+	addPins(t, ps)
+
+	// a negative threshold counts pins created just now as old enough
+	sweeper := NewPinSweeper("* * * * *", -time.Minute)
+	if err := sweeper.Run(context.Background(), nil, task.PinningServices{ps}, ""); err != nil {
+		t.Fatal(err)
+	}
+	pins := srv.Pins()
+	if len(pins) != 2 {
+		t.Fatalf("%d pins left, expected the 2 that weren't the monitor's", len(pins))
+	}
+	for _, p := range pins {
+		if p.Meta["task"] == "orphaned" {
+			t.Errorf("orphaned pin %s was not swept", p.Name)
+		}
+	}
+	if n := testutil.ToFloat64(sweeper.swept.WithLabelValues(ps.Name)); n != 1 {
+		t.Errorf("swept %v pins, expected 1", n)
+	}
+}
+
+func TestPinSweeperFailures(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(*pinningtest.Server)
+	}{
+		{"unavailable", func(s *pinningtest.Server) { s.FailRequests(1, http.StatusBadGateway) }},
+		{"unauthorized", func(s *pinningtest.Server) { s.RejectAuth(true) }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv, ps := newPinningService(t, "sweep-"+c.name)
+			addPins(t, ps)
+			c.setup(srv)
+
+			sweeper := NewPinSweeper("* * * * *", -time.Minute)
+			if err := sweeper.Run(context.Background(), nil, task.PinningServices{ps}, ""); err == nil {
+				t.Fatal("expected the sweep to fail")
+			}
+			if n := testutil.ToFloat64(pinning_errors.WithLabelValues(sweeper.Name(), ps.Name)); n != 1 {
+				t.Errorf("counted %v errors, expected 1", n)
+			}
+			if pins := srv.Pins(); len(pins) != 3 {
+				t.Errorf("%d pins left, expected all 3", len(pins))
+			}
+		})
+	}
+}
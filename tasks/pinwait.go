@@ -0,0 +1,185 @@
+package tasks
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pinning "github.com/ipfs/go-pinning-service-http-client"
+	"github.com/ipfs/go-pinning-service-http-client/openapi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
+)
+
+// Backoff describes how the delay between two polls grows.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+}
+
+// Validate checks the backoff waits between polls, and doesn't shrink.
+func (b Backoff) Validate() error {
+	if b.Initial <= 0 {
+		return fmt.Errorf("initial poll interval must be positive, got %s", b.Initial)
+	}
+	if b.Max < b.Initial {
+		return fmt.Errorf("maximum poll interval %s is below the initial %s", b.Max, b.Initial)
+	}
+	if b.Factor < 1 {
+		return fmt.Errorf("poll interval factor must be at least 1, got %v", b.Factor)
+	}
+	return nil
+}
+
+// Next returns the delay to use after d.
+func (b Backoff) Next(d time.Duration) time.Duration {
+	next := time.Duration(float64(d) * b.Factor)
+	if next > b.Max {
+		return b.Max
+	}
+	return next
+}
+
+// PinWaitBackoff controls how often the pinning service is polled while
+// waiting for a pin to complete.
+var PinWaitBackoff = Backoff{
+	Initial: 5 * time.Second,
+	Max:     time.Minute,
+	Factor:  2,
+}
+
+var pin_transition = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "gatewaymonitor_task",
+		Subsystem: "pinning",
+		Name:      "transition_seconds",
+		Help:      "time between first seeing a pin in one status and first seeing it in the next",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12), // 500ms-17 minutes
+	},
+	[]string{"pinning_service", "from", "to"})
+
+// Failures and errors of pinning tasks are counted in the common fail_count
+// and error_count like any other, and in these by pinning service.
+var (
+	pinning_fails = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pinning",
+			Name:      "fail_count",
+		},
+		[]string{"test", "pinning_service"})
+	pinning_errors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "pinning",
+			Name:      "error_count",
+		},
+		[]string{"test", "pinning_service"})
+)
+
+// pinFail counts a pinning service not doing what it should.
+func pinFail(labels prometheus.Labels, service string) {
+	fails.With(labels).Inc()
+	pinning_fails.WithLabelValues(labels["test"], service).Inc()
+}
+
+// pinError counts a request to a pinning service that went wrong.
+func pinError(labels prometheus.Labels, service string) {
+	errors.With(labels).Inc()
+	pinning_errors.WithLabelValues(labels["test"], service).Inc()
+}
+
+// waitForPin polls the pinning service until the pin request is pinned. It
+// gives up when the pin fails, the service rejects the request for its status
+// or ctx is done. Every status change seen along
+// the way is recorded in pin_transition, and failed polls are counted as
+// errors with errLabels.
+func waitForPin(
+	ctx context.Context,
+	ps task.PinningService,
+	pin pinning.PinStatusGetter,
+	backoff Backoff,
+	errLabels prometheus.Labels,
+) (_ pinning.PinStatusGetter, err error) {
+	service := ps.Name
+	requestID := pin.GetRequestId()
+	ctx, span := tracing.Tracer.Start(ctx, "pin_wait", trace.WithAttributes(
+		attribute.String("pinning_service", service),
+		attribute.String("request_id", requestID),
+	))
+	defer tracing.End(span, &err)
+	l := logger(ctx)
+	status := pin.GetStatus()
+	since := time.Now()
+	delay := backoff.Initial
+
+	for {
+		switch status {
+		case pinning.StatusPinned:
+			return pin, nil
+		case pinning.StatusFailed:
+			return pin, fmt.Errorf("pinning service %s reported pin %s as failed", service, requestID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return pin, fmt.Errorf("gave up waiting for pin %s in status %s: %w", requestID, status, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = backoff.Next(delay)
+
+		current, err := ps.GetStatusByID(ctx, requestID)
+		if err != nil {
+			pinError(errLabels, service)
+			if permanentPinningError(err) {
+				return pin, fmt.Errorf("pinning service %s rejected the status request for pin %s: %w", service, requestID, err)
+			}
+			// transient errors are counted, but we keep waiting
+			l.Warnw("failed to get pin status", "service", service, "requestid", requestID, "err", err)
+			continue
+		}
+		pin = current
+
+		if next := current.GetStatus(); next != status {
+			now := time.Now()
+			span.AddEvent(next.String())
+			l.Infow("pin status changed", "service", service, "requestid", requestID, "from", status, "to", next)
+			pin_transition.WithLabelValues(service, status.String(), next.String()).Observe(now.Sub(since).Seconds())
+			status = next
+			since = now
+		}
+	}
+}
+
+// pinningStatus returns the HTTP status code a pinning service answered a
+// failed request with, or 0 if it never answered.
+func pinningStatus(err error) int {
+	var oerr openapi.GenericOpenAPIError
+	if !stderrors.As(err, &oerr) {
+		return 0
+	}
+	// the client only keeps the status line, as in "404 Not Found"
+	code, _ := strconv.Atoi(strings.SplitN(oerr.Error(), " ", 2)[0])
+	return code
+}
+
+// permanentPinningError reports whether asking again won't help, as with a bad
+// token or an unknown request ID.
+func permanentPinningError(err error) bool {
+	code := pinningStatus(err)
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return code >= 400 && code < 500
+}
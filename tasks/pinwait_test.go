@@ -0,0 +1,120 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pinning "github.com/ipfs/go-pinning-service-http-client"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/pinningtest"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// fastBackoff polls often enough for tests to not wait on it.
+var fastBackoff = Backoff{Initial: 5 * time.Millisecond, Max: 20 * time.Millisecond, Factor: 2}
+
+// newPinningService starts a stand-in pinning service called name.
+func newPinningService(t *testing.T, name string) (*pinningtest.Server, task.PinningService) {
+	srv := pinningtest.NewServer("secret")
+	t.Cleanup(srv.Close)
+	return srv, task.NewPinningService(name, srv.URL, srv.Token)
+}
+
+func addPin(t *testing.T, ps task.PinningService) pinning.PinStatusGetter {
+	c, err := randomCID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, err := ps.Add(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pin
+}
+
+func TestBackoffValidate(t *testing.T) {
+	cases := []struct {
+		backoff Backoff
+		valid   bool
+	}{
+		{Backoff{Initial: time.Second, Max: time.Minute, Factor: 2}, true},
+		{Backoff{Initial: time.Second, Max: time.Second, Factor: 1}, true},
+		{Backoff{Initial: 0, Max: time.Minute, Factor: 2}, false},
+		{Backoff{Initial: -time.Second, Max: time.Minute, Factor: 2}, false},
+		{Backoff{Initial: time.Minute, Max: time.Second, Factor: 2}, false},
+		{Backoff{Initial: time.Second, Max: time.Minute, Factor: 0.5}, false},
+	}
+	for _, c := range cases {
+		if err := c.backoff.Validate(); (err == nil) != c.valid {
+			t.Errorf("%+v: valid is %t, expected %t (%v)", c.backoff, err == nil, c.valid, err)
+		}
+	}
+}
+
+func TestWaitForPinTransitions(t *testing.T) {
+	srv, ps := newPinningService(t, "wait-transitions")
+	srv.SetBehaviour(pinningtest.Behaviour{QueuedFor: 30 * time.Millisecond, PinningFor: 30 * time.Millisecond})
+	pin := addPin(t, ps)
+	// every poll but the last fails, and is retried
+	srv.FailRequests(2, http.StatusServiceUnavailable)
+
+	got, err := waitForPin(context.Background(), ps, pin, fastBackoff, prometheus.Labels{"test": "wait", "pop": "pinning", "location": "pinning", "size": "0", "code": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetStatus() != pinning.StatusPinned {
+		t.Errorf("pin is %s, expected pinned", got.GetStatus())
+	}
+	if found := metricLabels(pin_transition, prometheus.Labels{"pinning_service": ps.Name, "to": "pinned"}); len(found) != 1 {
+		t.Errorf("expected the transition to pinned to be recorded, got %v", found)
+	}
+}
+
+func TestWaitForPinFailed(t *testing.T) {
+	srv, ps := newPinningService(t, "wait-failed")
+	srv.SetBehaviour(pinningtest.Behaviour{QueuedFor: 10 * time.Millisecond, Fail: true})
+	pin := addPin(t, ps)
+
+	got, err := waitForPin(context.Background(), ps, pin, fastBackoff, prometheus.Labels{"test": "wait", "pop": "pinning", "location": "pinning", "size": "0", "code": "0"})
+	if err == nil {
+		t.Fatal("expected a failed pin to be an error")
+	}
+	if got.GetStatus() != pinning.StatusFailed {
+		t.Errorf("pin is %s, expected failed", got.GetStatus())
+	}
+}
+
+func TestWaitForPinUnauthorized(t *testing.T) {
+	srv, ps := newPinningService(t, "wait-unauthorized")
+	srv.SetBehaviour(pinningtest.Behaviour{QueuedFor: time.Hour})
+	pin := addPin(t, ps)
+	srv.RejectAuth(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := waitForPin(ctx, ps, pin, fastBackoff, prometheus.Labels{"test": "wait", "pop": "pinning", "location": "pinning", "size": "0", "code": "0"})
+	if err == nil {
+		t.Fatal("expected an unauthorized status request to be an error")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("kept polling after the service rejected the token")
+	}
+	if code := pinningStatus(err); code != http.StatusUnauthorized {
+		t.Errorf("status of the error is %d, expected 401 (%v)", code, err)
+	}
+	if calls := srv.Calls("GET /pins/{requestid}"); calls != 1 {
+		t.Errorf("polled %d times, expected to give up after 1", calls)
+	}
+}
+
+// withFastPolling makes tasks poll pin statuses with fastBackoff for the rest
+// of the test.
+func withFastPolling(t *testing.T) {
+	old := PinWaitBackoff
+	PinWaitBackoff = fastBackoff
+	t.Cleanup(func() { PinWaitBackoff = old })
+}
@@ -0,0 +1,127 @@
+package tasks
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// IdleReadTimeout aborts a download when no bytes of the body arrive for this
+// long. The run is then counted as stalled rather than as a plain error.
+var IdleReadTimeout = time.Minute
+
+const progressSampleInterval = time.Second
+
+// fraction of the body for which we report time-to-fraction
+var progressMilestones = []float64{0.5, 0.9, 1.0}
+
+type progressSample struct {
+	elapsed time.Duration
+	bytes   int64
+}
+
+// progressReader wraps a response body and records how the download progressed
+// over time: a coarse timeline of bytes received, the longest gap between two
+// reads, and when each of progressMilestones was reached.
+type progressReader struct {
+	r     io.Reader
+	start time.Time
+	total int64
+
+	received     int64
+	last         time.Time
+	longestStall time.Duration
+	milestones   []time.Duration
+	samples      []progressSample
+
+	timeout time.Duration
+	idle    *time.Timer
+	stalled int32
+}
+
+// newProgressReader starts tracking r. Elapsed times are measured from start,
+// total is the expected body size (0 if unknown), and abort is called if the
+// body goes idle for longer than timeout.
+func newProgressReader(r io.Reader, start time.Time, total int64, timeout time.Duration, abort func()) *progressReader {
+	p := &progressReader{
+		r:          r,
+		start:      start,
+		total:      total,
+		timeout:    timeout,
+		last:       time.Now(),
+		milestones: make([]time.Duration, len(progressMilestones)),
+	}
+	for i := range p.milestones {
+		p.milestones[i] = -1
+	}
+	if timeout > 0 {
+		p.idle = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&p.stalled, 1)
+			abort()
+		})
+	}
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n <= 0 {
+		return n, err
+	}
+
+	now := time.Now()
+	if p.idle != nil {
+		p.idle.Reset(p.timeout)
+	}
+	if gap := now.Sub(p.last); gap > p.longestStall {
+		p.longestStall = gap
+	}
+	p.last = now
+	p.received += int64(n)
+
+	elapsed := now.Sub(p.start)
+	if len(p.samples) == 0 || elapsed-p.samples[len(p.samples)-1].elapsed >= progressSampleInterval {
+		p.samples = append(p.samples, progressSample{elapsed, p.received})
+	}
+	if p.total > 0 {
+		for i, m := range progressMilestones {
+			if p.milestones[i] < 0 && float64(p.received) >= m*float64(p.total) {
+				p.milestones[i] = elapsed
+			}
+		}
+	}
+	return n, err
+}
+
+// Done stops the idle timer. It must be called once the body has been read,
+// or reading it was given up on. The time since the last read counts as a
+// stall too, as that is the one that got the download aborted.
+func (p *progressReader) Done() {
+	if p.idle != nil {
+		p.idle.Stop()
+	}
+	if gap := time.Since(p.last); gap > p.longestStall {
+		p.longestStall = gap
+	}
+	if len(p.samples) == 0 || p.samples[len(p.samples)-1].bytes != p.received {
+		p.samples = append(p.samples, progressSample{p.last.Sub(p.start), p.received})
+	}
+}
+
+// Stalled reports whether the download was aborted by the idle timeout.
+func (p *progressReader) Stalled() bool {
+	return atomic.LoadInt32(&p.stalled) == 1
+}
+
+// Timeline renders the samples as "seconds:bytes" pairs for logging.
+func (p *progressReader) Timeline() string {
+	var out string
+	for i, s := range p.samples {
+		if i > 0 {
+			out += " "
+		}
+		out += fmt.Sprintf("%.1f:%d", s.elapsed.Seconds(), s.bytes)
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// slowReader sends its chunks one per read, waiting delay before each, and
+// then blocks until unblock is closed if that is set.
+type slowReader struct {
+	chunks  [][]byte
+	delay   time.Duration
+	unblock chan struct{}
+}
+
+func (r *slowReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		if r.unblock != nil {
+			<-r.unblock
+			return 0, io.ErrUnexpectedEOF
+		}
+		time.Sleep(r.delay)
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(b, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestProgressReaderFinished(t *testing.T) {
+	r := &slowReader{
+		chunks: [][]byte{[]byte("hello "), []byte("world")},
+		delay:  20 * time.Millisecond,
+	}
+	p := newProgressReader(r, time.Now(), 11, time.Minute, func() { t.Error("aborted a download that didn't stall") })
+	if _, err := io.Copy(ioutil.Discard, p); err != nil {
+		t.Fatal(err)
+	}
+	p.Done()
+
+	if p.Stalled() {
+		t.Error("download reported as stalled")
+	}
+	if p.longestStall < r.delay {
+		t.Errorf("longest stall is %s, expected at least %s", p.longestStall, r.delay)
+	}
+	for i, m := range p.milestones {
+		if m < 0 {
+			t.Errorf("milestone %v not reached", progressMilestones[i])
+		}
+	}
+}
+
+func TestProgressReaderStalled(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	r := &slowReader{
+		chunks:  [][]byte{[]byte("hello")},
+		unblock: make(chan struct{}),
+	}
+	p := newProgressReader(r, time.Now(), 100, timeout, func() { close(r.unblock) })
+	if _, err := io.Copy(ioutil.Discard, p); err == nil {
+		t.Fatal("expected the stalled download to fail")
+	}
+	p.Done()
+
+	if !p.Stalled() {
+		t.Error("download not reported as stalled")
+	}
+	// the stall that got it aborted is the longest one
+	if p.longestStall < timeout {
+		t.Errorf("longest stall is %s, expected at least the idle timeout of %s", p.longestStall, timeout)
+	}
+	if p.received != 5 {
+		t.Errorf("received %d bytes, expected 5", p.received)
+	}
+}
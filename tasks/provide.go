@@ -0,0 +1,139 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// ProvideBeforeFetch makes every content task provide its root CID, and wait
+// for it to be discoverable, before fetching it from the gateway. Tasks can
+// also opt in one by one with SetProvide.
+var ProvideBeforeFetch = false
+
+// DiscoveryNode is the node asked to find providers of the content. It has to
+// be a node other than the local one, which answers findprovs from its own
+// provider records first. When it is nil, content is only provided, and
+// nothing is recorded about it being discoverable.
+var DiscoveryNode task.Node
+
+// DiscoverTimeout is how long to poll for providers before giving up and
+// fetching anyway. No more than half of what is left of the run's deadline is
+// spent on it, so the fetch still gets the rest.
+var DiscoverTimeout = 2 * time.Minute
+
+const (
+	discoverPollInterval = 5 * time.Second
+	// how many providers to ask for on each poll
+	discoverMaxProviders = 20
+)
+
+var (
+	provide_time = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      "provide_seconds",
+			Help:      "time for the local node to provide the root CID of the content",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 13), // 100ms-7 minutes
+		},
+		labelNames(defaultLabels, "dag"))
+	discover_time = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      "discoverable_seconds",
+			Help:      "time from starting to provide until findprovs returned the local node",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 13), // 100ms-7 minutes
+		},
+		labelNames(defaultLabels, "dag"))
+	undiscoverable = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      "undiscoverable_count",
+			Help:      "runs where the content wasn't discoverable within the timeout",
+		},
+		labelNames(defaultLabels, "dag"))
+)
+
+// provideAndDiscover provides c from the local node, then polls DiscoveryNode
+// for providers until the local node is among them. The content is fetched
+// from the gateway either way, so only errors in providing, or ctx being done,
+// are returned.
+func provideAndDiscover(ctx context.Context, node task.Node, t task.Task, size int, c string) error {
+	localLabels := task.Labels(t, "localhost", size, 0)
+	dagLabels := prometheus.Labels{"dag": layoutOf(t).String()}
+	for k, v := range localLabels {
+		dagLabels[k] = v
+	}
+
+	id, err := node.ID(ctx)
+	if err != nil {
+		errors.With(localLabels).Inc()
+		return fmt.Errorf("%s(%d): failed to get local peer ID: %w", t.Name(), size, err)
+	}
+
+	logger(ctx).Infof("%s(%d): providing %s", t.Name(), size, c)
+	start := time.Now()
+	if err := node.Provide(ctx, c); err != nil {
+		errors.With(localLabels).Inc()
+		return fmt.Errorf("%s(%d): failed to provide %s: %w", t.Name(), size, c, err)
+	}
+	provided := time.Since(start)
+	provide_time.With(dagLabels).Observe(provided.Seconds())
+	logger(ctx).Infof("%s(%d): provided %s in %f seconds", t.Name(), size, c, provided.Seconds())
+
+	discovery := DiscoveryNode
+	if discovery == nil {
+		return nil
+	}
+	timeout := discoverTimeout(ctx)
+	discoverCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(discoverPollInterval)
+	defer ticker.Stop()
+	for {
+		providers, err := discovery.FindProviders(discoverCtx, c, discoverMaxProviders)
+		if err != nil {
+			logger(ctx).Warnw("findprovs failed", "cid", c, "err", err)
+		}
+		for _, p := range providers {
+			if p == id.ID {
+				discovered := time.Since(start)
+				discover_time.With(dagLabels).Observe(discovered.Seconds())
+				logger(ctx).Infof("%s(%d): %s discoverable after %f seconds", t.Name(), size, c, discovered.Seconds())
+				return nil
+			}
+		}
+
+		select {
+		case <-discoverCtx.Done():
+			if ctx.Err() != nil {
+				// the run is over, not just the wait
+				return ctx.Err()
+			}
+			undiscoverable.With(dagLabels).Inc()
+			logger(ctx).Warnf("%s(%d): %s not discoverable within %s, fetching anyway", t.Name(), size, c, timeout)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// discoverTimeout is DiscoverTimeout, cut down to half of the time left until
+// ctx's deadline.
+func discoverTimeout(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return DiscoverTimeout
+	}
+	if half := time.Until(deadline) / 2; half < DiscoverTimeout {
+		return half
+	}
+	return DiscoverTimeout
+}
@@ -0,0 +1,82 @@
+package tasks
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipfs"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+func TestDiscoverTimeout(t *testing.T) {
+	defer func(d time.Duration) { DiscoverTimeout = d }(DiscoverTimeout)
+	DiscoverTimeout = time.Minute
+
+	if d := discoverTimeout(context.Background()); d != time.Minute {
+		t.Errorf("timeout without a deadline is %s, expected a minute", d)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if d := discoverTimeout(ctx); d != time.Minute {
+		t.Errorf("timeout with plenty of time left is %s, expected a minute", d)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if d := discoverTimeout(ctx); d > 30*time.Second || d < 29*time.Second {
+		t.Errorf("timeout with a minute left is %s, expected half of it", d)
+	}
+}
+
+func TestProvideAndDiscover(t *testing.T) {
+	defer func(d time.Duration, n task.Node) { DiscoverTimeout, DiscoveryNode = d, n }(DiscoverTimeout, DiscoveryNode)
+	DiscoverTimeout = 50 * time.Millisecond
+
+	node := ipfs.NewMemoryNode()
+	c, err := node.Add(context.Background(), strings.NewReader("provided"), task.Layout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bench := NewRandomLocalBench("* * * * *", 0)
+	labels := task.Labels(bench, "localhost", 0, 0)
+	labels["dag"] = bench.Layout().String()
+	missed := func() float64 { return testutil.ToFloat64(undiscoverable.With(labels)) }
+
+	// without a node to discover it from, the content is only provided
+	DiscoveryNode = nil
+	if err := provideAndDiscover(context.Background(), node, bench, 0, c); err != nil {
+		t.Fatal(err)
+	}
+	if found := metricLabels(provide_time, labels); len(found) != 1 {
+		t.Errorf("expected the provide to be recorded, got %v", found)
+	}
+	if found := metricLabels(discover_time, labels); len(found) != 0 {
+		t.Errorf("recorded as discoverable without a discovery node: %v", found)
+	}
+	if n := missed(); n != 0 {
+		t.Fatalf("counted as undiscoverable %v times", n)
+	}
+
+	// a node elsewhere never does
+	DiscoveryNode = ipfs.NewMemoryNode()
+	if err := provideAndDiscover(context.Background(), node, bench, 0, c); err != nil {
+		t.Fatal(err)
+	}
+	if n := missed(); n != 1 {
+		t.Fatalf("counted as undiscoverable %v times, expected once", n)
+	}
+
+	// and when the run is cancelled, that's not on discovery
+	DiscoverTimeout = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := provideAndDiscover(ctx, node, bench, 0, c); err != context.Canceled {
+		t.Fatalf("returned %v, expected the run's cancellation", err)
+	}
+	if n := missed(); n != 1 {
+		t.Errorf("counted as undiscoverable %v times, expected only the one before", n)
+	}
+}
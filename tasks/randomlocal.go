@@ -6,43 +6,46 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 
-	shell "github.com/ipfs/go-ipfs-api"
-	pinning "github.com/ipfs/go-pinning-service-http-client"
-
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
 )
 
 type RandomLocalBench struct {
+	prober
+	publisher
+
 	reg        *task.Registration
 	size       int
-	latency    *prometheus.HistogramVec
-	fetch_time *prometheus.HistogramVec
+	latency    prometheus.ObserverVec
+	fetch_time prometheus.ObserverVec
 }
 
 func NewRandomLocalBench(schedule string, size int) *RandomLocalBench {
-	latency := prometheus.NewHistogramVec(
+	latency := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "random_local",
 			Name:      "latency_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 12, 11), // 0-2 minutes
 		},
-		defaultLabels,
+		labelNames(requestLabels, "dag"),
 	)
-	fetch_time := prometheus.NewHistogramVec(
+	fetch_time := sharedHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "random_local",
 			Name:      "fetch_seconds",
 			Buckets:   prometheus.LinearBuckets(0, 15, 16), // 0-4 minutes
 		},
-		defaultLabels,
+		labelNames(requestLabels, "dag"),
 	)
 	reg := task.Registration{
-		Schedule: schedule,
+		Schedule:  schedule,
+		NeedsNode: true,
 		Collectors: []prometheus.Collector{
 			latency,
 			fetch_time,
+			probe_ttfb,
+			probe_fetch,
 		},
 	}
 	return &RandomLocalBench{
@@ -57,36 +60,42 @@ func (t *RandomLocalBench) Name() string {
 	return "random_local"
 }
 
-func (t *RandomLocalBench) LatencyHist() *prometheus.HistogramVec {
+func (t *RandomLocalBench) LatencyHist() prometheus.ObserverVec {
 	return t.latency
 }
 
-func (t *RandomLocalBench) FetchHist() *prometheus.HistogramVec {
+func (t *RandomLocalBench) FetchHist() prometheus.ObserverVec {
 	return t.fetch_time
 }
 
-func (t *RandomLocalBench) Run(ctx context.Context, sh *shell.Shell, ps *pinning.Client, gw string) error {
-	defer gc(ctx, sh)
+func (t *RandomLocalBench) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	defer gc(ctx, node)
 
-	cidstr, randb, err := addRandomData(sh, t, t.size)
+	cidstr, p, err := addRandomData(ctx, node, t, t.size)
 	if err != nil {
 		return err
 	}
 
 	defer func() {
 		localLabels := task.Labels(t, "localhost", t.size, 0)
-		log.Info("Unpinning test CID")
-		err := sh.Unpin(cidstr)
+		logger(ctx).Info("Unpinning test CID")
+		err := unpin(ctx, node, cidstr)
 		if err != nil {
-			log.Warnw("Failed to clean unpin cid.", "cid", cidstr)
+			logger(ctx).Warnw("Failed to clean unpin cid.", "cid", cidstr)
 			errors.With(localLabels).Inc()
 		}
 	}()
 
+	if t.Provide() {
+		if err := provideAndDiscover(ctx, node, t, t.size, cidstr); err != nil {
+			return p.annotate(err)
+		}
+	}
+
 	// request from gateway, observing client metrics
 	url := fmt.Sprintf("%s/ipfs/%s", gw, cidstr)
 
-	return checkAndRecord(ctx, t, gw, url, randb)
+	return p.annotate(checkAndRecord(ctx, t, &t.prober, gw, url, p.size, p.Reader, p))
 }
 
 func (t *RandomLocalBench) Registration() *task.Registration {
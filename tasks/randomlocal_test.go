@@ -0,0 +1,126 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/resultlog"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// tamper serves h's responses with the body changed by f.
+func tamper(h http.Handler, f func([]byte) []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			if k != "Content-Length" {
+				w.Header()[k] = v
+			}
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(f(rec.Body.Bytes()))
+	})
+}
+
+func TestRandomLocalBench(t *testing.T) {
+	node, gw := newMemoryGateway(t)
+
+	bench := NewRandomLocalBench("* * * * *", 300*kiB)
+	if err := bench.Run(context.Background(), node, nil, gw); err != nil {
+		t.Fatal(err)
+	}
+	if found := metricLabels(probe_fetch, map[string]string{"test": bench.Name(), "code": "200"}); len(found) == 0 {
+		t.Error("expected the fetch to be recorded")
+	}
+	if entries := Ledger.Entries(); len(entries) != 0 {
+		t.Errorf("ledger still has %v", entries)
+	}
+}
+
+func TestRandomLocalBenchCorrupt(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func([]byte) []byte
+		want   string
+	}{
+		{"flipped", func(b []byte) []byte {
+			if len(b) > 1000 {
+				b[1000] ^= 0xff
+			}
+			return b
+		}, "byte offset 1000"},
+		{"truncated", func(b []byte) []byte {
+			return b[:len(b)/2]
+		}, "payload seed"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, _ := newMemoryGateway(t)
+			srv := httptest.NewServer(tamper(node.Gateway(), c.tamper))
+			defer srv.Close()
+
+			bench := NewRandomLocalBench("* * * * *", 300*kiB)
+			err := bench.Run(context.Background(), node, nil, srv.URL)
+			if err == nil {
+				t.Fatal("expected the changed content to be caught")
+			}
+			if !strings.Contains(err.Error(), c.want) || !strings.Contains(err.Error(), "payload seed") {
+				t.Errorf("error %q doesn't mention %q and the seed", err, c.want)
+			}
+		})
+	}
+}
+
+func TestRandomLocalBenchResults(t *testing.T) {
+	node, gw := newMemoryGateway(t)
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	results, err := resultlog.Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Results = results
+	t.Cleanup(func() {
+		Results = nil
+		results.Close()
+	})
+
+	bench := NewRandomLocalBench("* * * * *", 300*kiB)
+	bench.SetLayout(task.Layout{CidVersion: 1, RawLeaves: true})
+	if err := bench.Run(context.Background(), node, nil, gw); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r resultlog.Result
+	if err := json.Unmarshal(bytes.SplitN(b, []byte("\n"), 2)[0], &r); err != nil {
+		t.Fatal(err)
+	}
+	p := r.Payload
+	if p == nil {
+		t.Fatalf("result %s names no payload", b)
+	}
+	if p.Dag != "v1-raw" || p.Size != 300*kiB {
+		t.Errorf("payload is %+v, expected a v1-raw one of %d bytes", p, 300*kiB)
+	}
+
+	// the record is all it takes to regenerate the content
+	layout := task.Layout{CidVersion: p.CidVersion, Hash: p.Hash, Chunker: p.Chunker, RawLeaves: p.RawLeaves}
+	c, err := node.Add(context.Background(), PayloadReader(p.Seed, p.Size), layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != r.CID {
+		t.Errorf("regenerated %s, expected %s", c, r.CID)
+	}
+}
@@ -4,126 +4,255 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/ipfs/go-cid"
-	shell "github.com/ipfs/go-ipfs-api"
 	pinning "github.com/ipfs/go-pinning-service-http-client"
+	"github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ledger"
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
 )
 
 type RandomPinningBench struct {
+	prober
+	publisher
+
 	reg        *task.Registration
 	size       int
-	latency    *prometheus.HistogramVec
-	fetch_time *prometheus.HistogramVec
+	origins    originsPicker
+	delegates  bool
+	pin_time   prometheus.ObserverVec
+	latency    prometheus.ObserverVec
+	fetch_time prometheus.ObserverVec
 }
 
 func NewRandomPinningBench(schedule string, size int) *RandomPinningBench {
-	latency := prometheus.NewHistogramVec(
+	latency := sharedHistogramVec(
 		prometheus.HistogramOpts{
-			Namespace:   "gatewaymonitor_task",
-			Subsystem:   "random_pinning",
-			Name:        "latency_seconds",
-			Buckets:     prometheus.LinearBuckets(0, 12, 11), // 0-2 minutes
-			ConstLabels: map[string]string{"size": strconv.Itoa(size)},
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "random_pinning",
+			Name:      "latency_seconds",
+			Buckets:   prometheus.LinearBuckets(0, 12, 11), // 0-2 minutes
 		},
-		defaultLabels)
+		labelNames(requestLabels, "dag"))
 
-	fetch_time := prometheus.NewHistogramVec(
+	fetch_time := sharedHistogramVec(
 		prometheus.HistogramOpts{
-			Namespace:   "gatewaymonitor_task",
-			Subsystem:   "random_pinning",
-			Name:        "fetch_seconds",
-			Buckets:     prometheus.LinearBuckets(0, 15, 16), // 0-4 minutes
-			ConstLabels: map[string]string{"size": strconv.Itoa(size)},
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "random_pinning",
+			Name:      "fetch_seconds",
+			Buckets:   prometheus.LinearBuckets(0, 15, 16), // 0-4 minutes
 		},
-		defaultLabels)
+		labelNames(requestLabels, "dag"))
+
+	pin_time := sharedHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "random_pinning",
+			Name:      "pin_seconds",
+			Help:      "time from asking the pinning service to pin until it reports the pin as pinned",
+			Buckets:   prometheus.LinearBuckets(0, 60, 16), // 0-15 minutes
+		},
+		[]string{"pinning_service", "origins", "size", "dag"})
 
 	reg := task.Registration{
-		Schedule: schedule,
+		Schedule:  schedule,
+		NeedsNode: true,
 		Collectors: []prometheus.Collector{
+			pin_time,
 			latency,
 			fetch_time,
+			probe_ttfb,
+			probe_fetch,
 		},
 	}
 	return &RandomPinningBench{
 		reg:        &reg,
 		size:       size,
+		pin_time:   pin_time,
 		latency:    latency,
 		fetch_time: fetch_time,
 	}
 }
 
+// WithOrigins makes the bench pass the local node's addresses as origins when
+// pinning, according to mode. If connectDelegates is set, the local node also
+// connects to the delegates the pinning service hands back.
+func (t *RandomPinningBench) WithOrigins(mode OriginsMode, connectDelegates bool) *RandomPinningBench {
+	t.origins.mode = mode
+	t.delegates = connectDelegates
+	return t
+}
+
 func (t *RandomPinningBench) Name() string {
 	return "random_pinning"
 }
 
-func (t *RandomPinningBench) LatencyHist() *prometheus.HistogramVec {
+func (t *RandomPinningBench) LatencyHist() prometheus.ObserverVec {
 	return t.latency
 }
 
-func (t *RandomPinningBench) FetchHist() *prometheus.HistogramVec {
+func (t *RandomPinningBench) FetchHist() prometheus.ObserverVec {
 	return t.fetch_time
 }
 
-func (t *RandomPinningBench) Run(ctx context.Context, sh *shell.Shell, ps *pinning.Client, gw string) error {
-	defer gc(ctx, sh)
+func (t *RandomPinningBench) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	if len(ps) == 0 {
+		logger(ctx).Infof("%s: no pinning service configured, skipping", t.Name())
+		return nil
+	}
+
+	defer gc(ctx, node)
 
 	localLabels := task.Labels(t, "localhost", t.size, 0)
-	pinLabels := task.Labels(t, "pinning", t.size, 0)
 
-	cidstr, randb, err := addRandomData(sh, t, t.size)
+	cidstr, p, err := addRandomData(ctx, node, t, t.size)
 	if err != nil {
 		return err
 	}
 
 	defer func() {
-		log.Info("Unpinning test CID")
+		logger(ctx).Info("Unpinning test CID")
 		// don't bother error checking. We clean it up explicitly in the happy path.
-		sh.Unpin(cidstr)
+		unpin(ctx, node, cidstr)
 	}()
 
-	// Pin to pinning service
+	if t.Provide() {
+		if err := provideAndDiscover(ctx, node, t, t.size, cidstr); err != nil {
+			return p.annotate(err)
+		}
+	}
+
 	c, err := cid.Decode(cidstr)
 	if err != nil {
 		errors.With(localLabels).Inc()
 		return fmt.Errorf("failed to decode cid after it was returned from IPFS: %w", err)
 	}
-	getter, err := ps.Add(ctx, c)
-	if err != nil {
-		errors.With(pinLabels).Inc()
-		return fmt.Errorf("failed to pin cid to pinning service: %w", err)
+
+	var origins []multiaddr.Multiaddr
+	if t.origins.next() {
+		origins, err = localOrigins(ctx, node)
+		if err != nil {
+			// carry on without, it'll be recorded as such
+			errors.With(localLabels).Inc()
+			logger(ctx).Warnw("pinning without origins", "err", err)
+		}
 	}
 
-	// long poll pinning service
-	log.Info("waiting for pinning service to complete the pin")
-	var pinned bool
-	for !pinned {
-		status, err := ps.GetStatusByID(ctx, getter.GetRequestId())
+	// Pin the same content to every pinning service at once, so they are
+	// all compared under the same conditions.
+	pins := make([]pinning.PinStatusGetter, len(ps))
+	pinErrs := make([]error, len(ps))
+	var wg sync.WaitGroup
+	for i := range ps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pins[i], pinErrs[i] = t.pin(ctx, node, ps[i], c, origins)
+		}(i)
+	}
+	wg.Wait()
+
+	defer func() {
+		// the run's context may already be done, but the pins still have to go
+		cleanCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		for i, pin := range pins {
+			if pin == nil {
+				continue
+			}
+			logger(ctx).Infow("Removing pin from pinning service", "service", ps[i].Name)
+			delCtx, span := tracing.Tracer.Start(cleanCtx, "cleanup_remote_pin", trace.WithAttributes(
+				attribute.String("pinning_service", ps[i].Name),
+				attribute.String("request_id", pin.GetRequestId()),
+			))
+			err := ps[i].DeleteByID(delCtx, pin.GetRequestId())
+			tracing.End(span, &err)
+			if err != nil {
+				pinError(t.pinLabels(), ps[i].Name)
+				logger(ctx).Warnw("failed to remove pin from pinning service. the ledger cleanup will retry.", "service", ps[i].Name, "requestid", pin.GetRequestId(), "err", err)
+				continue
+			}
+			untrack(ledger.Entry{Kind: ledger.RemotePin, ID: pin.GetRequestId(), Service: ps[i].Name})
+		}
+	}()
+
+	var pinErr error
+	var pinned int
+	for _, err := range pinErrs {
 		if err == nil {
-			fmt.Println(status.GetStatus())
-			pinned = status.GetStatus() == pinning.StatusPinned
-		} else {
-			errors.With(pinLabels).Inc()
-			fmt.Println(err)
+			pinned++
+		} else if pinErr == nil {
+			pinErr = err
 		}
-		time.Sleep(time.Minute)
+	}
+	if pinned == 0 {
+		return pinErr
 	}
 
 	// delete this from our local IPFS node.
-	log.Info("removing pin from local IPFS node")
-	err = sh.Unpin(cidstr)
+	logger(ctx).Info("removing pin from local IPFS node")
+	err = unpin(ctx, node, cidstr)
 	if err != nil {
 		errors.With(localLabels).Inc()
 		return fmt.Errorf("Could not unpin cid after adding it earlier: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/ipfs/%s", gw, cidstr)
-	return checkAndRecord(ctx, t, gw, url, randb)
+	if err := checkAndRecord(ctx, t, &t.prober, gw, url, p.size, p.Reader, p); err != nil {
+		return p.annotate(err)
+	}
+	return pinErr
+}
+
+// pin asks a pinning service to pin c and waits until it has. The returned pin
+// is set whenever the service accepted the request, even if it later failed.
+func (t *RandomPinningBench) pin(
+	ctx context.Context,
+	node task.Node,
+	ps task.PinningService,
+	c cid.Cid,
+	origins []multiaddr.Multiaddr,
+) (pinning.PinStatusGetter, error) {
+	pinLabels := t.pinLabels()
+
+	opts := monitorPinOpts(t.Name(), nil)
+	if len(origins) > 0 {
+		opts = append(opts, pinning.PinOpts.WithOrigins(origins...))
+	}
+
+	start := time.Now()
+	getter, err := ps.Add(ctx, c, opts...)
+	if err != nil {
+		pinError(pinLabels, ps.Name)
+		return nil, fmt.Errorf("failed to pin cid to pinning service %s: %w", ps.Name, err)
+	}
+	track(t, ledger.Entry{Kind: ledger.RemotePin, ID: getter.GetRequestId(), Service: ps.Name})
+
+	if t.delegates {
+		connectDelegates(ctx, node, ps.Name, getter.GetDelegates())
+	}
+
+	// long poll pinning service
+	logger(ctx).Infow("waiting for pinning service to complete the pin", "service", ps.Name)
+	_, err = waitForPin(ctx, ps, getter, PinWaitBackoff, pinLabels)
+	if err != nil {
+		pinFail(pinLabels, ps.Name)
+		return getter, err
+	}
+	t.pin_time.WithLabelValues(ps.Name, strconv.FormatBool(len(origins) > 0), strconv.Itoa(t.size), t.Layout().String()).Observe(time.Since(start).Seconds())
+	return getter, nil
+}
+
+func (t *RandomPinningBench) pinLabels() prometheus.Labels {
+	return task.Labels(t, "pinning", t.size, 0)
 }
 
 func (t *RandomPinningBench) Registration() *task.Registration {
@@ -0,0 +1,99 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipfs"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/pinningtest"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// newMemoryGateway starts a MemoryNode along with a gateway serving it.
+func newMemoryGateway(t *testing.T) (*ipfs.MemoryNode, string) {
+	node := ipfs.NewMemoryNode()
+	srv := httptest.NewServer(node.Gateway())
+	t.Cleanup(srv.Close)
+	return node, srv.URL
+}
+
+func TestRandomPinningBench(t *testing.T) {
+	withFastPolling(t)
+	node, gw := newMemoryGateway(t)
+	fast, fastPS := newPinningService(t, "bench-fast")
+	slow, slowPS := newPinningService(t, "bench-slow")
+	slow.SetBehaviour(pinningtest.Behaviour{QueuedFor: 50 * time.Millisecond, PinningFor: 50 * time.Millisecond})
+
+	bench := NewRandomPinningBench("* * * * *", 64*kiB)
+	if err := bench.Run(context.Background(), node, task.PinningServices{fastPS, slowPS}, gw); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, srv := range []*pinningtest.Server{fast, slow} {
+		if pins := srv.Pins(); len(pins) != 0 {
+			t.Errorf("%d pins left behind on the pinning service", len(pins))
+		}
+	}
+	for _, ps := range []task.PinningService{fastPS, slowPS} {
+		// pin_time is shared by every bench, so only look at this test's services
+		if found := metricLabels(bench.pin_time, map[string]string{"pinning_service": ps.Name}); len(found) != 1 {
+			t.Errorf("expected the pin time to be recorded for %s, got %v", ps.Name, found)
+		}
+	}
+	if found := metricLabels(pin_transition, map[string]string{"pinning_service": slowPS.Name, "from": "queued", "to": "pinning"}); len(found) != 1 {
+		t.Errorf("expected the slow service's transition out of queued to be recorded, got %v", found)
+	}
+	if entries := Ledger.Entries(); len(entries) != 0 {
+		t.Errorf("ledger still has %v", entries)
+	}
+}
+
+func TestRandomPinningBenchFailures(t *testing.T) {
+	withFastPolling(t)
+	cases := []struct {
+		name    string
+		setup   func(*pinningtest.Server)
+		counter string
+	}{
+		{"failed", func(s *pinningtest.Server) {
+			s.SetBehaviour(pinningtest.Behaviour{QueuedFor: 10 * time.Millisecond, Fail: true})
+		}, "fails"},
+		{"unavailable", func(s *pinningtest.Server) {
+			s.FailRequests(1, http.StatusServiceUnavailable)
+		}, "errors"},
+		{"unauthorized", func(s *pinningtest.Server) {
+			s.RejectAuth(true)
+		}, "errors"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, gw := newMemoryGateway(t)
+			srv, ps := newPinningService(t, "bench-"+c.name)
+			c.setup(srv)
+
+			bench := NewRandomPinningBench("* * * * *", 64*kiB)
+			if err := bench.Run(context.Background(), node, task.PinningServices{ps}, gw); err == nil {
+				t.Fatal("expected the run to fail")
+			}
+
+			counter := pinning_errors
+			if c.counter == "fails" {
+				counter = pinning_fails
+			}
+			if n := testutil.ToFloat64(counter.WithLabelValues(bench.Name(), ps.Name)); n != 1 {
+				t.Errorf("%s counted %v times, expected once", c.counter, n)
+			}
+			if pins := srv.Pins(); len(pins) != 0 {
+				t.Errorf("%d pins left behind on the pinning service", len(pins))
+			}
+			if entries := Ledger.Entries(); len(entries) != 0 {
+				t.Errorf("ledger still has %v", entries)
+			}
+		})
+	}
+}
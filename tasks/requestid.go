@@ -0,0 +1,128 @@
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// version can be set at build time with
+// -ldflags "-X github.com/ipfs-shipyard/gateway-monitor/tasks.version=...".
+var version string
+
+// Version returns the version of the monitor: the one set at build time, or
+// else the module version or VCS revision the binary was built from.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			return s.Value[:12]
+		}
+	}
+	return "devel"
+}
+
+// UserAgent identifies the monitor, and its version, to gateways.
+var UserAgent = "gateway-monitor/" + Version()
+
+// RequestIDHeader is the header every request to the gateway carries a unique
+// ID in, so gateway operators can find it in their logs. Empty to send none.
+var RequestIDHeader = "X-Request-Id"
+
+// responseIDHeaders are where gateways, and the CDNs in front of them, put
+// their own ID for a request.
+var responseIDHeaders = []string{
+	"X-Request-Id",
+	"CF-Ray",
+	"X-Amz-Cf-Id",
+	"Fly-Request-Id",
+}
+
+// tagRequest sets the User-Agent and a new request ID on req, returning the ID.
+func tagRequest(req *http.Request) string {
+	req.Header.Set("User-Agent", UserAgent)
+	if RequestIDHeader == "" {
+		return ""
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	req.Header.Set(RequestIDHeader, id)
+	return id
+}
+
+// responseIDs returns the IDs the gateway gave the request, by header.
+func responseIDs(resp *http.Response) map[string]string {
+	ids := make(map[string]string)
+	for _, h := range responseIDHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			ids[http.CanonicalHeaderKey(h)] = v
+		}
+	}
+	return ids
+}
+
+// exemplar ties an observation to the request: its trace, the ID sent with it
+// and the ID the gateway answered with, for as many of them as fit in the 128
+// characters an exemplar may have.
+func exemplar(ctx context.Context, requestID string, respIDs map[string]string) prometheus.Labels {
+	ex := prometheus.Labels{}
+	length := 0
+	add := func(name, value string) {
+		n := utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+		if value == "" || !utf8.ValidString(value) || length+n > prometheus.ExemplarMaxRunes {
+			return
+		}
+		ex[name] = value
+		length += n
+	}
+	add("request_id", requestID)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		add("trace_id", sc.TraceID().String())
+	}
+	for _, h := range responseIDHeaders {
+		if v := respIDs[http.CanonicalHeaderKey(h)]; v != "" && v != requestID {
+			add("response_id", v)
+			break
+		}
+	}
+	return ex
+}
+
+// inc counts one on c, with ex as the exemplar.
+func inc(c *prometheus.CounterVec, labels prometheus.Labels, ex prometheus.Labels) {
+	counter := c.With(labels)
+	if a, ok := counter.(prometheus.ExemplarAdder); ok && len(ex) > 0 {
+		a.AddWithExemplar(1, ex)
+		return
+	}
+	counter.Inc()
+}
+
+// observe records v on h, with ex as the exemplar.
+func observe(h prometheus.ObserverVec, labels prometheus.Labels, v float64, ex prometheus.Labels) {
+	o := h.With(labels)
+	if e, ok := o.(prometheus.ExemplarObserver); ok && len(ex) > 0 {
+		e.ObserveWithExemplar(v, ex)
+		return
+	}
+	o.Observe(v)
+}
@@ -0,0 +1,131 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestExemplar(t *testing.T) {
+	requestID := strings.Repeat("a", 32)
+	traced := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{1},
+	}))
+	traceID := trace.TraceID{1}.String()
+
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		respIDs map[string]string
+		want    prometheus.Labels
+	}{
+		{"request id", context.Background(), nil,
+			prometheus.Labels{"request_id": requestID}},
+		{"traced", traced, nil,
+			prometheus.Labels{"request_id": requestID, "trace_id": traceID}},
+		{"cf-ray", traced, map[string]string{"Cf-Ray": "8a1b2c3d4e5f-AMS"},
+			prometheus.Labels{"request_id": requestID, "trace_id": traceID, "response_id": "8a1b2c3d4e5f-AMS"}},
+		{"gateway request id", context.Background(), map[string]string{"X-Request-Id": "gw-1234"},
+			prometheus.Labels{"request_id": requestID, "response_id": "gw-1234"}},
+		{"echoed request id", context.Background(), map[string]string{"X-Request-Id": requestID},
+			prometheus.Labels{"request_id": requestID}},
+		// request_id and trace_id take 82 of the 128 runes
+		{"over budget", traced, map[string]string{"Cf-Ray": strings.Repeat("r", 36)},
+			prometheus.Labels{"request_id": requestID, "trace_id": traceID}},
+		{"at budget", traced, map[string]string{"Cf-Ray": strings.Repeat("r", 35)},
+			prometheus.Labels{"request_id": requestID, "trace_id": traceID, "response_id": strings.Repeat("r", 35)}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := exemplar(c.ctx, requestID, c.respIDs)
+			runes := 0
+			for k, v := range got {
+				runes += utf8.RuneCountInString(k) + utf8.RuneCountInString(v)
+			}
+			if runes > prometheus.ExemplarMaxRunes {
+				t.Errorf("exemplar has %d runes, more than %d", runes, prometheus.ExemplarMaxRunes)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("exemplar is %v, expected %v", got, c.want)
+			}
+			for k, v := range c.want {
+				if got[k] != v {
+					t.Errorf("exemplar is %v, expected %v", got, c.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestFailureExemplar(t *testing.T) {
+	pop := "exemplar"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-IPFS-POP", pop)
+		w.Header().Set("CF-Ray", "8a1b2c3d4e5f-AMS")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	body := []byte("Hello World!\r\n")
+	check := NewKnownGoodCheck("* * * * *", map[string][]byte{"/ipfs/hello": body})
+	if err := check.Run(context.Background(), nil, nil, srv.URL); err == nil {
+		t.Fatal("expected the check to fail on a 502")
+	}
+
+	metrics, _ := collected(fails, prometheus.Labels{"test": check.Name(), "pop": pop})
+	if len(metrics) != 1 || metrics[0].Counter.GetExemplar() == nil {
+		t.Fatalf("expected a failure with an exemplar, got %v", metrics)
+	}
+	ex := make(map[string]string)
+	for _, l := range metrics[0].Counter.GetExemplar().GetLabel() {
+		ex[l.GetName()] = l.GetValue()
+	}
+	if ex["response_id"] != "8a1b2c3d4e5f-AMS" {
+		t.Errorf("exemplar is %v, expected the CF-Ray as response_id", ex)
+	}
+	if ex["request_id"] == "" {
+		t.Errorf("exemplar is %v, expected the request_id sent", ex)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	defer func(h string) { RequestIDHeader = h }(RequestIDHeader)
+
+	for _, header := range []string{"X-Request-Id", "X-Correlation-Id", ""} {
+		t.Run("header="+header, func(t *testing.T) {
+			RequestIDHeader = header
+			var got http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				w.Write([]byte("Hello World!\r\n"))
+			}))
+			defer srv.Close()
+
+			check := NewKnownGoodCheck("* * * * *", map[string][]byte{"/ipfs/hello": []byte("Hello World!\r\n")})
+			if err := check.Run(context.Background(), nil, nil, srv.URL); err != nil {
+				t.Fatal(err)
+			}
+
+			if ua := got.Get("User-Agent"); ua != UserAgent || !strings.HasPrefix(ua, "gateway-monitor/") {
+				t.Errorf("User-Agent is %q, expected %q", ua, UserAgent)
+			}
+			for _, h := range []string{"X-Request-Id", "X-Correlation-Id"} {
+				id := got.Get(h)
+				if h == header && len(id) != 32 {
+					t.Errorf("%s is %q, expected a request ID", h, id)
+				}
+				if h != header && id != "" {
+					t.Errorf("%s is %q, expected none", h, id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package tasks
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/resultlog"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+)
+
+// Results gets the result of every probe of the gateway, if set.
+var Results *resultlog.Log
+
+// resultLabels are the labels copied into results, when set, to tell task
+// instances apart.
+var resultLabels = []string{"size", "dag", "key_type", "ttl"}
+
+func newResult(t task.Task, gw string, u string, labels prometheus.Labels) resultlog.Result {
+	r := resultlog.Result{
+		Time:   time.Now(),
+		Task:   t.Name(),
+		Target: gw,
+		URL:    u,
+		CID:    cidFromURL(u),
+		Labels: make(map[string]string),
+	}
+	labels = withVariant(t, labels)
+	for _, l := range resultLabels {
+		if v := labels[l]; v != "" && v != "0" {
+			r.Labels[l] = v
+		}
+	}
+	return r
+}
+
+// cidFromURL returns the CID of an /ipfs/ URL.
+func cidFromURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimPrefix(parsed.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != "ipfs" {
+		return ""
+	}
+	return parts[1]
+}
+
+// recordResult writes r to Results. A probe that failed with err is an error,
+// unless r says otherwise.
+func recordResult(ctx context.Context, r resultlog.Result, err error) {
+	if Results == nil {
+		return
+	}
+	switch {
+	case err == nil:
+		r.Outcome = resultlog.OK
+	case r.Outcome == "" || r.Outcome == resultlog.OK:
+		r.Outcome = resultlog.Error
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.TraceID = sc.TraceID().String()
+	}
+	if err := Results.Write(r); err != nil {
+		log.Warnw("failed to write probe result", "err", err)
+	}
+}
@@ -0,0 +1,400 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipnsrecord"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/resultlog"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
+)
+
+// RoutingV1Check publishes fresh content and an IPNS name, then asks the
+// gateway's Delegated Routing HTTP API (/routing/v1) for the providers of the
+// content, the IPNS record and the local node's peer record. The endpoints are
+// polled side by side until the local node shows up in each answer, or
+// DiscoverTimeout.
+type RoutingV1Check struct {
+	prober
+
+	reg     *task.Registration
+	latency prometheus.ObserverVec
+	results *prometheus.GaugeVec
+	invalid *prometheus.CounterVec
+	found   prometheus.ObserverVec
+	missing *prometheus.CounterVec
+}
+
+const routingV1PayloadSize = 1 * kiB
+
+func NewRoutingV1Check(schedule string) *RoutingV1Check {
+	latency := sharedHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "routing_v1",
+			Name:      "request_seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12), // 10ms-20 seconds
+		},
+		[]string{"endpoint", "code"})
+
+	results := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "routing_v1",
+			Name:      "results",
+			Help:      "records returned by the last request to each endpoint",
+		},
+		[]string{"endpoint"})
+
+	invalid := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "routing_v1",
+			Name:      "invalid_response_count",
+			Help:      "responses that didn't match the Delegated Routing HTTP API schema",
+		},
+		[]string{"endpoint"})
+
+	found := sharedHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "routing_v1",
+			Name:      "found_seconds",
+			Help:      "time from publishing until the endpoint returned the local node's records",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 13), // 100ms-7 minutes
+		},
+		[]string{"endpoint"})
+
+	missing := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "routing_v1",
+			Name:      "missing_count",
+			Help:      "runs where the endpoint never returned the local node's records",
+		},
+		[]string{"endpoint"})
+
+	reg := task.Registration{
+		Schedule:  schedule,
+		NeedsNode: true,
+		Collectors: []prometheus.Collector{
+			latency,
+			results,
+			invalid,
+			found,
+			missing,
+		},
+	}
+	return &RoutingV1Check{
+		reg:     &reg,
+		latency: latency,
+		results: results,
+		invalid: invalid,
+		found:   found,
+		missing: missing,
+	}
+}
+
+func (t *RoutingV1Check) Name() string {
+	return "routing_v1"
+}
+
+func (t *RoutingV1Check) LatencyHist() prometheus.ObserverVec {
+	return nil
+}
+
+func (t *RoutingV1Check) FetchHist() prometheus.ObserverVec {
+	return nil
+}
+
+// routingProbe is one endpoint to query. check validates a 200 response,
+// returning how many records it held and whether the local node's was one.
+type routingProbe struct {
+	endpoint string
+	path     string
+	accept   string
+	check    func(contentType string, body []byte) (count int, found bool, err error)
+}
+
+// schemaError is a response that doesn't match the API schema. Unlike other
+// errors, polling again won't fix it.
+type schemaError struct {
+	error
+}
+
+func (t *RoutingV1Check) Run(ctx context.Context, node task.Node, ps task.PinningServices, gw string) error {
+	defer gc(ctx, node)
+
+	localLabels := task.Labels(t, "localhost", routingV1PayloadSize, 0)
+
+	cidstr, p, err := addRandomData(ctx, node, t, routingV1PayloadSize)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := unpin(ctx, node, cidstr); err != nil {
+			errors.With(localLabels).Inc()
+			logger(ctx).Warnw("failed to clean unpin cid.", "cid", cidstr)
+		}
+	}()
+
+	keyName := fmt.Sprintf("%s%x", ipnsKeyPrefix, uint64(p.seed))
+	removeKey, err := newKey(ctx, node, t, localLabels, keyName, "")
+	if err != nil {
+		errors.With(localLabels).Inc()
+		return err
+	}
+	defer removeKey()
+
+	id, err := node.ID(ctx)
+	if err != nil {
+		errors.With(localLabels).Inc()
+		return fmt.Errorf("%s: failed to get local peer ID: %w", t.Name(), err)
+	}
+
+	published := time.Now()
+	if err := node.Provide(ctx, cidstr); err != nil {
+		errors.With(localLabels).Inc()
+		return fmt.Errorf("%s: failed to provide %s: %w", t.Name(), cidstr, err)
+	}
+	pubCtx, pubSpan := tracing.Tracer.Start(ctx, "ipns_publish")
+	name, err := node.Publish(pubCtx, cidstr, keyName, time.Hour, time.Minute)
+	tracing.End(pubSpan, &err)
+	if err != nil {
+		errors.With(localLabels).Inc()
+		return fmt.Errorf("%s: failed to publish IPNS name: %w", t.Name(), err)
+	}
+
+	probes := []routingProbe{
+		{
+			endpoint: "providers",
+			path:     "/routing/v1/providers/" + cidstr,
+			accept:   "application/json",
+			check:    checkRecords("Providers", id.ID),
+		},
+		{
+			endpoint: "ipns",
+			path:     "/routing/v1/ipns/" + name,
+			accept:   "application/vnd.ipfs.ipns-record",
+			check:    checkIpnsRecord(name, "/ipfs/"+cidstr),
+		},
+		{
+			endpoint: "peers",
+			path:     "/routing/v1/peers/" + id.ID,
+			accept:   "application/json",
+			check:    checkRecords("Peers", id.ID),
+		},
+	}
+
+	// every endpoint gets the same deadline, counted from publishing
+	timeout := discoverTimeout(ctx)
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errs := make([]error, len(probes))
+	var wg sync.WaitGroup
+	for i := range probes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = t.poll(pollCtx, gw, probes[i], published, timeout)
+		}(i)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var failed error
+	for i, err := range errs {
+		if err != nil {
+			fails.With(localLabels).Inc()
+			logger(ctx).Errorw("routing endpoint check failed", "endpoint", probes[i].endpoint, "err", err)
+			failed = err
+		}
+	}
+	return failed
+}
+
+// poll queries an endpoint until it returns the local node's records, or ctx
+// is done. timeout is only for the error message.
+func (t *RoutingV1Check) poll(ctx context.Context, gw string, probe routingProbe, published time.Time, timeout time.Duration) error {
+	ticker := time.NewTicker(discoverPollInterval)
+	defer ticker.Stop()
+
+	for {
+		count, found, err := t.query(ctx, gw, probe)
+		if _, ok := err.(schemaError); ok {
+			t.invalid.WithLabelValues(probe.endpoint).Inc()
+			return fmt.Errorf("%s: invalid response from %s%s: %w", t.Name(), gw, probe.path, err)
+		}
+		if err == nil {
+			t.results.WithLabelValues(probe.endpoint).Set(float64(count))
+		}
+		if found {
+			t.found.WithLabelValues(probe.endpoint).Observe(time.Since(published).Seconds())
+			logger(ctx).Infow("routing endpoint returned local records", "endpoint", probe.endpoint, "results", count, "seconds", time.Since(published).Seconds())
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			t.missing.WithLabelValues(probe.endpoint).Inc()
+			if err != nil {
+				return fmt.Errorf("%s: %s: local records not returned within %s. last error: %w", t.Name(), probe.endpoint, timeout, err)
+			}
+			return fmt.Errorf("%s: %s: local records not among %d results within %s", t.Name(), probe.endpoint, count, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *RoutingV1Check) query(ctx context.Context, gw string, probe routingProbe) (count int, found bool, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "gateway_request", trace.WithAttributes(
+		attribute.String("url", gw+probe.path),
+		attribute.String("endpoint", probe.endpoint),
+	))
+	defer tracing.End(span, &err)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", gw+probe.path, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Accept", probe.accept)
+	tracing.Inject(ctx, req.Header)
+	requestID := tagRequest(req)
+	span.SetAttributes(attribute.String("request_id", requestID))
+
+	start := time.Now()
+	result := newResult(t, gw, gw+probe.path, nil)
+	result.Labels["endpoint"] = probe.endpoint
+	result.RequestID = requestID
+	defer func() {
+		result.Total = time.Since(start).Seconds()
+		if _, ok := err.(schemaError); ok {
+			result.Outcome = resultlog.Fail
+		}
+		recordResult(ctx, result, err)
+	}()
+
+	resp, err := t.client().Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	result.ResponseIDs = responseIDs(resp)
+	body, err := ioutil.ReadAll(resp.Body)
+	t.latency.WithLabelValues(probe.endpoint, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.Int("status", resp.StatusCode))
+	result.Status, result.Proto, result.Bytes = resp.StatusCode, resp.Proto, int64(len(body))
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return probe.check(resp.Header.Get("Content-Type"), body)
+	case http.StatusNotFound:
+		// no records, yet
+		return 0, false, nil
+	}
+	result.Outcome = resultlog.Fail
+	return 0, false, fmt.Errorf("unexpected status %d. request id: %s", resp.StatusCode, requestID)
+}
+
+// checkRecords checks a JSON response holding a list of records under field,
+// looking for the record of peer.
+func checkRecords(field string, peer string) func(string, []byte) (int, bool, error) {
+	return func(contentType string, body []byte) (int, bool, error) {
+		if err := checkContentType(contentType, "application/json"); err != nil {
+			return 0, false, err
+		}
+		var resp map[string]json.RawMessage
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return 0, false, schemaError{fmt.Errorf("body is not a JSON object: %w", err)}
+		}
+		raw, ok := resp[field]
+		if !ok {
+			return 0, false, schemaError{fmt.Errorf("missing %s field", field)}
+		}
+		var records []json.RawMessage
+		if err := json.Unmarshal(raw, &records); err != nil {
+			return 0, false, schemaError{fmt.Errorf("%s is not a list: %w", field, err)}
+		}
+
+		found := false
+		for i, r := range records {
+			var rec struct {
+				Schema *string
+				ID     *string
+				Addrs  []string
+			}
+			if err := json.Unmarshal(r, &rec); err != nil {
+				return 0, false, schemaError{fmt.Errorf("%s[%d] is not a record: %w", field, i, err)}
+			}
+			if rec.Schema == nil || *rec.Schema == "" {
+				return 0, false, schemaError{fmt.Errorf("%s[%d] has no Schema", field, i)}
+			}
+			// clients must skip schemas they don't know
+			if *rec.Schema != "peer" {
+				continue
+			}
+			if rec.ID == nil || *rec.ID == "" {
+				return 0, false, schemaError{fmt.Errorf("%s[%d] is a peer record without an ID", field, i)}
+			}
+			for _, a := range rec.Addrs {
+				if _, err := multiaddr.NewMultiaddr(a); err != nil {
+					return 0, false, schemaError{fmt.Errorf("%s[%d] has an invalid address %q: %w", field, i, a, err)}
+				}
+			}
+			if *rec.ID == peer {
+				found = true
+			}
+		}
+		return len(records), found, nil
+	}
+}
+
+// checkIpnsRecord checks an IPNS record response is a record for name, signed
+// by its key. Finding it means it points at value; until then it may still be
+// an older record.
+func checkIpnsRecord(name string, value string) func(string, []byte) (int, bool, error) {
+	return func(contentType string, body []byte) (int, bool, error) {
+		if err := checkContentType(contentType, "application/vnd.ipfs.ipns-record"); err != nil {
+			return 0, false, err
+		}
+		record, err := ipnsrecord.Decode(body)
+		if err != nil {
+			return 0, false, schemaError{err}
+		}
+		if err := record.Verify(name); err != nil {
+			return 0, false, schemaError{err}
+		}
+		return 1, record.Value == value, nil
+	}
+}
+
+func checkContentType(got string, want string) error {
+	mediaType, _, err := mime.ParseMediaType(got)
+	if err != nil || mediaType != want {
+		return schemaError{fmt.Errorf("content type is %q, expected %s", got, want)}
+	}
+	return nil
+}
+
+func (t *RoutingV1Check) Registration() *task.Registration {
+	return t.reg
+}
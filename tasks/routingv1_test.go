@@ -0,0 +1,73 @@
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipnsrecord"
+)
+
+func TestRoutingV1Check(t *testing.T) {
+	node, gw := newMemoryGateway(t)
+
+	check := NewRoutingV1Check("* * * * *")
+	if err := check.Run(context.Background(), node, nil, gw); err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range []string{"providers", "ipns", "peers"} {
+		if found := metricLabels(check.found, map[string]string{"endpoint": endpoint}); len(found) != 1 {
+			t.Errorf("expected %s to have found the local records", endpoint)
+		}
+	}
+	if keys := node.Keys(); len(keys) != 0 {
+		t.Errorf("keys %v left behind", keys)
+	}
+}
+
+func TestRoutingV1CheckForgedRecord(t *testing.T) {
+	node, _ := newMemoryGateway(t)
+	// a record with the right value, but signed by some other key
+	forger, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := node.Gateway()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/routing/v1/ipns/") {
+			gateway.ServeHTTP(w, r)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/routing/v1/ipns/")
+		path, err := node.Resolve(r.Context(), name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		record, _ := ipnsrecord.Create(forger, path, 0, time.Now().Add(time.Hour), time.Minute)
+		w.Header().Set("Content-Type", "application/vnd.ipfs.ipns-record")
+		w.Write(record)
+	}))
+	defer srv.Close()
+
+	check := NewRoutingV1Check("* * * * *")
+	err = check.Run(context.Background(), node, nil, srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("expected the forged record to be caught, got %v", err)
+	}
+	if n := testutil.ToFloat64(check.invalid.WithLabelValues("ipns")); n != 1 {
+		t.Errorf("counted %v invalid responses, expected 1", n)
+	}
+	for _, endpoint := range []string{"providers", "peers"} {
+		if found := metricLabels(check.found, map[string]string{"endpoint": endpoint}); len(found) == 0 {
+			t.Errorf("expected %s to have found the local records all the same", endpoint)
+		}
+	}
+}
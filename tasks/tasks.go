@@ -1,32 +1,62 @@
 package tasks
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptrace"
-	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
-	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
+	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ledger"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/resultlog"
 	"github.com/ipfs-shipyard/gateway-monitor/pkg/task"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
 )
 
 // This file contains the list of tasks to be run (see All)
 // as well as common metrics that might be useful for more than one task.
 
 func init() {
+	// the probe histograms are registered by the tasks that fetch, like their
+	// own histograms, so ConfigureHistograms can still change them
 	prometheus.Register(fetch_speed)
 	prometheus.Register(fetch_latency)
 	prometheus.Register(fails)
 	prometheus.Register(errors)
+	prometheus.Register(fetch_stall)
+	prometheus.Register(fetch_progress)
+	prometheus.Register(stalls)
+	prometheus.Register(phase_dns)
+	prometheus.Register(phase_connect)
+	prometheus.Register(phase_tls)
+	prometheus.Register(phase_server)
+	prometheus.Register(phase_transfer)
+	prometheus.Register(connections)
+	prometheus.Register(pin_transition)
+	prometheus.Register(pinning_fails)
+	prometheus.Register(pinning_errors)
+	prometheus.Register(delegate_connect)
+	prometheus.Register(delegate_connect_errors)
+	prometheus.Register(cleanup_pending)
+	prometheus.Register(cleanup_cleaned)
+	prometheus.Register(cleanup_abandoned)
+	prometheus.Register(provide_time)
+	prometheus.Register(discover_time)
+	prometheus.Register(undiscoverable)
 }
 
 const (
@@ -39,20 +69,82 @@ var (
 	log = logging.Logger("tasks")
 
 	defaultLabels = []string{"test", "pop", "location", "size", "code"}
+	// requestLabels are on the metrics recorded for every request to the
+	// gateway.
+	requestLabels = labelNames(defaultLabels, "conn", "proto")
 
 	All = []task.Task{
 		NewRandomLocalBench("10,30,50 * * * *", 16*miB),
 		NewRandomLocalBench("20 * * * *", 256*miB),
 		NewIpnsBench("10,30,50 * * * *", 16*miB),
 		NewIpnsBench("40 * * * *", 256*miB),
-		NewKnownGoodCheck("* * * * *", map[string][]byte{
+		withConnMode(ConnModePaired, NewKnownGoodCheck("* * * * *", map[string][]byte{
 			"/ipfs/Qmc5gCcjYypU7y28oCALwfSvxCBskLuPKWpK4qpterKC7z": []byte("Hello World!\r\n"),
-		}),
+		})),
 		NewNonExistCheck("0 * * * *"),
+		NewRandomPinningBench("25 * * * *", 16*miB).WithOrigins(OriginsDefault, true),
+		NewPinningApiCheck("15 * * * *"),
+		NewPinSweeper("45 */6 * * *", 24*time.Hour),
+		NewLedgerCleanup("55 * * * *"),
+		NewRemotePinCleanup("55 * * * *"),
+		NewRoutingV1Check("5,35 * * * *"),
 	}
 
-	// Histogram metrics are defined in each test because the buckets are different between tests
-	// Yes, it's annoying (especially when creating the dashboards)
+	// Matrix runs the benches again for every DAG layout and kind of IPNS
+	// record a gateway may treat differently. That is 24 more runs an hour,
+	// each on a minute of its own, so it is left out of All unless asked for.
+	Matrix = concat(
+		// gateways behave differently depending on how the DAG is built. Each
+		// of these adds 4 MiB and fetches it, usually within a minute.
+		withLayouts(
+			layoutMatrix(
+				[]int{0, 1},
+				[]string{"sha2-256", "blake3"},
+				[]string{"size-262144", "rabin", "buzhash"},
+				[]bool{false, true},
+			),
+			func(i int) interface {
+				task.Task
+				SetLayout(task.Layout)
+			} {
+				return NewRandomLocalBench(hourly(2, 4, i), 4*miB)
+			},
+		),
+		// and may treat some names differently, such as RSA keys or short
+		// TTLs. Each of these publishes a name for 1 MiB and resolves it,
+		// which can take a few minutes.
+		withIpnsParams(
+			ipnsMatrix(
+				[]string{"ed25519", "rsa", "secp256k1"},
+				[]IpnsParams{
+					{Lifetime: time.Hour, TTL: time.Minute},
+					{Lifetime: time.Hour, TTL: time.Hour},
+					{Lifetime: 48 * time.Hour, TTL: time.Hour},
+				},
+			),
+			func(i int) *IpnsBench {
+				return NewIpnsBench(hourly(3, 6, i), 1*miB)
+			},
+		),
+	)
+
+	// Every task's response times are recorded in the gatewaymonitor_probe_*
+	// histograms, told apart by the test label. They are native histograms,
+	// so one dashboard query covers all tasks. The classic buckets are for
+	// Prometheus setups that don't scrape native histograms. Like the tasks'
+	// own histograms, they can be changed with ConfigureHistograms.
+	probe_ttfb = newProbeHistogram(
+		"ttfb_seconds",
+		"time from sending the request to the gateway until the first byte of the response",
+		prometheus.ExponentialBuckets(0.01, 2, 16)) // 10ms-5 minutes
+	probe_fetch = newProbeHistogram(
+		"fetch_seconds",
+		"time from sending the request to the gateway until the whole response was received",
+		prometheus.ExponentialBuckets(0.05, 2, 14)) // 50ms-7 minutes
+
+	// Tasks also define their own latency and fetch histograms, with buckets
+	// to suit each of them. These are only recorded if LegacyHistograms is set,
+	// while dashboards move over to the probe histograms.
 
 	fetch_speed = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -60,14 +152,36 @@ var (
 			Subsystem: "common",
 			Name:      "fetch_speed_bytes_per_second",
 		},
-		defaultLabels)
+		requestLabels)
 	fetch_latency = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: "gatewaymonitor_task",
 			Subsystem: "common",
 			Name:      "fetch_latency_seconds",
 		},
-		defaultLabels)
+		requestLabels)
+	fetch_stall = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      "fetch_longest_stall_seconds",
+		},
+		requestLabels)
+	fetch_progress = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      "fetch_progress_seconds",
+			Help:      "time from request start until the given fraction of the body was received",
+		},
+		labelNames(requestLabels, "progress"))
+	stalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      "stall_count",
+		},
+		requestLabels)
 	fails = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "gatewaymonitor_task",
@@ -84,77 +198,283 @@ var (
 		defaultLabels)
 )
 
+// Several instances of the same task, told apart by their labels, share
+// histograms. Otherwise only the first instance's would get registered.
+var (
+	histogramsMu sync.Mutex
+	histograms   = make(map[string]*taskHistogram)
+)
+
+// sharedHistogramVec creates a task histogram, or returns the one that was
+// already created under the same name. Its buckets can be changed with
+// ConfigureHistograms.
+func sharedHistogramVec(opts prometheus.HistogramOpts, labels []string) prometheus.ObserverVec {
+	histogramsMu.Lock()
+	defer histogramsMu.Unlock()
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	if h, ok := histograms[name]; ok {
+		return h
+	}
+	h := &taskHistogram{opts: opts, labels: labels}
+	histograms[name] = h
+	return h
+}
+
+// LegacyHistograms keeps recording each task's own latency and fetch
+// histograms, as well as the probe histograms.
+var LegacyHistograms = true
+
+func newProbeHistogram(name string, help string, buckets []float64) prometheus.ObserverVec {
+	return sharedHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                       "gatewaymonitor",
+			Subsystem:                       "probe",
+			Name:                            name,
+			Help:                            help,
+			Buckets:                         buckets,
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  160,
+			NativeHistogramMinResetDuration: time.Hour,
+		},
+		requestLabels)
+}
+
+// labelNames returns names followed by more, without changing names.
+func labelNames(names []string, more ...string) []string {
+	return append(append([]string(nil), names...), more...)
+}
+
+// variant is implemented by tasks that run as several instances, such as one
+// per layout. The labels it returns tell the instances apart. Only the task's
+// own metrics, and its results, carry them.
+type variant interface {
+	variantLabels() prometheus.Labels
+}
+
+// withVariant returns labels, plus the labels of t's variant if it has one.
+func withVariant(t task.Task, labels prometheus.Labels) prometheus.Labels {
+	all := make(prometheus.Labels, len(labels))
+	for k, v := range labels {
+		all[k] = v
+	}
+	if v, ok := t.(variant); ok {
+		for k, v := range v.variantLabels() {
+			all[k] = v
+		}
+	}
+	return all
+}
+
+// withConn returns labels, plus those of a request over conn that was answered
+// with proto.
+func withConn(labels prometheus.Labels, conn ConnMode, proto string) prometheus.Labels {
+	all := prometheus.Labels{"conn": string(conn), "proto": proto}
+	for k, v := range labels {
+		all[k] = v
+	}
+	return all
+}
+
+func concat(groups ...[]task.Task) []task.Task {
+	var all []task.Task
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
+
+// hourly returns the schedule of the i-th of several tasks that run once an
+// hour, one every step minutes from minute first, so they don't all queue up
+// at once.
+func hourly(first int, step int, i int) string {
+	return fmt.Sprintf("%d * * * *", (first+step*i)%60)
+}
+
+// withConnMode overrides DefaultConnMode for one of the tasks in All.
+func withConnMode(m ConnMode, t interface {
+	task.Task
+	SetConnMode(ConnMode)
+}) task.Task {
+	t.SetConnMode(m)
+	return t
+}
+
+// logger is log, with the trace ID of the task run in ctx.
+func logger(ctx context.Context) *zap.SugaredLogger {
+	return tracing.Logger(ctx, &log.SugaredLogger)
+}
+
 // This is here to keep the volume size down
 // Tasks that create pins should clean up after themselves
-// and run this.
-func gc(ctx context.Context, sh *shell.Shell) error {
-	log.Info("GCing repo")
-	req := sh.Request("repo/gc")
-	_, err := req.Send(ctx)
+// and run this. It only collects garbage once the ledger says
+// at least GCThreshold bytes were unpinned, since it also
+// collects anything else that isn't pinned on the node.
+func gc(ctx context.Context, node task.Node) (err error) {
+	if garbage := Ledger.Garbage(); garbage < GCThreshold {
+		logger(ctx).Debugf("not GCing repo, only %d bytes unpinned since the last gc", garbage)
+		return nil
+	}
+	ctx, span := tracing.Tracer.Start(ctx, "ipfs_gc")
+	defer tracing.End(span, &err)
+	logger(ctx).Info("GCing repo")
+	err = node.GC(ctx)
 	if err != nil {
-		log.Warnw("failed to gc repo.", "err", err)
+		logger(ctx).Warnw("failed to gc repo.", "err", err)
+		return err
 	}
-	return err
+	if err := Ledger.Collected(); err != nil {
+		logger(ctx).Warnw("failed to record gc in ledger", "err", err)
+	}
+	return nil
 }
 
-func addRandomData(sh *shell.Shell, t task.Task, size int) (string, []byte, error) {
+func addRandomData(ctx context.Context, node task.Node, t task.Task, size int) (cidstr string, p *payload, err error) {
+	layout := layoutOf(t)
+
+	ctx, span := tracing.Tracer.Start(ctx, "generate_content", trace.WithAttributes(
+		attribute.Int("size", size),
+		attribute.String("dag", layout.String()),
+	))
+	defer tracing.End(span, &err)
+	l := logger(ctx)
+
 	localLabels := task.Labels(t, "localhost", size, 0)
 
 	// generate random data
-	log.Infof("%s(%d): generating %d bytes random data", t.Name(), size, size)
-	randb := make([]byte, size)
-	if _, err := rand.Read(randb); err != nil {
+	p, err = newRandomPayload(size)
+	if err != nil {
 		errors.With(localLabels).Inc()
-		return "", []byte{}, fmt.Errorf("%s(%d): failed to generate random values: %w", t.Name(), size, err)
+		return "", nil, fmt.Errorf("%s(%d): failed to generate random seed: %w", t.Name(), size, err)
 	}
-	buf := bytes.NewReader(randb)
-
-	// add to local ipfs
-	log.Infof("%s(%d): writing data to local IPFS node", t.Name(), size)
-	cidstr, err := sh.Add(buf)
+	span.SetAttributes(attribute.Int64("seed", p.seed))
+	l.Infof("%s(%d): generating %d bytes random data from seed %d", t.Name(), size, size, p.seed)
+
+	// add to local ipfs, streaming the content as it is generated
+	l.Infof("%s(%d): writing data to local IPFS node. layout: %s", t.Name(), size, layout)
+	addCtx, addSpan := tracing.Tracer.Start(ctx, "ipfs_add")
+	cidstr, err = node.Add(addCtx, p.Reader(), layout)
+	tracing.End(addSpan, &err)
 	if err != nil {
 		errors.With(localLabels).Inc()
-		return "", []byte{}, fmt.Errorf("%s(%d): failed to write to IPFS: %w", t.Name(), size, err)
+		return "", nil, p.annotate(fmt.Errorf("%s(%d): failed to write to IPFS: %w", t.Name(), size, err))
 	}
+	span.SetAttributes(attribute.String("cid", cidstr))
+	track(t, ledger.Entry{Kind: ledger.LocalPin, ID: cidstr, Size: int64(size)})
+	l.Infof("%s(%d): added payload with seed %d as %s", t.Name(), size, p.seed, cidstr)
 
-	return cidstr, randb, nil
+	return cidstr, p, nil
 }
 
+// randomCID makes up a CID that nobody is going to be providing.
+func randomCID() (cid.Cid, error) {
+	buf := make([]byte, 128)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	encoded, err := multihash.EncodeName(buf, "sha3")
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to generate multihash of random bytes: %w", err)
+	}
+	cast, err := multihash.Cast(encoded)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to cast as multihash: %w", err)
+	}
+
+	return cid.NewCidV1(cid.Raw, cast), nil
+}
+
+// checkAndRecord fetches url from the gateway once for each measurement the
+// task's connection mode asks for, checking the body against expected. pl is
+// the payload expected was generated from, if any, for the results to name.
 func checkAndRecord(
 	ctx context.Context,
 	t task.Task,
+	p *prober,
 	gw string,
 	url string,
-	expected []byte,
+	size int,
+	expected func() io.Reader,
+	pl *payload,
 ) error {
-	size := len(expected)
+	for _, conn := range p.conns() {
+		err := fetchAndRecord(ctx, t, conn, gw, url, size, expected(), pl)
+		if conn.fresh {
+			conn.client.CloseIdleConnections()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fetchAndRecord(
+	ctx context.Context,
+	t task.Task,
+	conn probeConn,
+	gw string,
+	url string,
+	size int,
+	expected io.Reader,
+	pl *payload,
+) (err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "gateway_request", trace.WithAttributes(
+		attribute.String("url", url),
+		attribute.String("conn", string(conn.mode)),
+	))
+	defer tracing.End(span, &err)
+	l := logger(ctx)
+
 	remoteLabels := task.Labels(t, gw, size, 0)
 
-	log.Infof("%s(%d): fetching from gateway. url: %s", t.Name(), size, url)
+	l.Infof("%s(%d): fetching from gateway over %s connection. url: %s", t.Name(), size, conn.mode, url)
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
 	req, _ := http.NewRequest("GET", url, nil)
 	start := time.Now()
 
 	var firstByteTime time.Time
 
-	trace := &httptrace.ClientTrace{
-		GotFirstResponseByte: func() {
-			latency := time.Since(start).Seconds()
-			log.Infof("%s(%d): first byte received in %f seconds", t.Name(), size, latency)
-			firstByteTime = time.Now()
-		},
+	result := newResult(t, gw, url, remoteLabels)
+	result.Conn = string(conn.mode)
+	if pl != nil {
+		result.Payload = pl.result(layoutOf(t))
 	}
-
-	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
-	resp, err := http.DefaultClient.Do(req)
+	defer func() {
+		if !firstByteTime.IsZero() {
+			result.TTFB = firstByteTime.Sub(start).Seconds()
+		}
+		result.Total = time.Since(start).Seconds()
+		recordResult(ctx, result, err)
+	}()
+
+	phases := newPhaseTrace(func() {
+		latency := time.Since(start).Seconds()
+		l.Infof("%s(%d): first byte received in %f seconds", t.Name(), size, latency)
+		firstByteTime = time.Now()
+	})
+
+	req = req.WithContext(httptrace.WithClientTrace(ctx, phases.ClientTrace()))
+	tracing.Inject(ctx, req.Header)
+	requestID := tagRequest(req)
+	result.RequestID = requestID
+	span.SetAttributes(attribute.String("request_id", requestID))
+	l = l.With("request_id", requestID)
+	ex := exemplar(ctx, requestID, nil)
+
+	resp, err := conn.client.Do(req)
 	if err != nil {
-		errors.With(remoteLabels).Inc()
-		return fmt.Errorf("%s(%d): failed to fetch from gateway %w", t.Name(), size, err)
+		inc(errors, remoteLabels, ex)
+		return fmt.Errorf("%s(%d): failed to fetch from gateway %w. request id: %s", t.Name(), size, err, requestID)
 	}
 
-	respb, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		errors.With(remoteLabels).Inc()
-		return fmt.Errorf("%s(%d): failed to download content: %w", t.Name(), size, err)
+	defer resp.Body.Close()
+	result.ResponseIDs = responseIDs(resp)
+	ex = exemplar(ctx, requestID, result.ResponseIDs)
+	if len(result.ResponseIDs) > 0 {
+		l = l.With("response_ids", result.ResponseIDs)
 	}
 
 	pop := resp.Header.Get("X-IPFS-POP")
@@ -163,42 +483,95 @@ func checkAndRecord(
 	}
 
 	responseLabels := task.Labels(t, pop, size, resp.StatusCode)
+	reqLabels := withConn(responseLabels, conn.mode, resp.Proto)
+	result.Pop, result.Status, result.Proto = pop, resp.StatusCode, resp.Proto
+	span.SetAttributes(
+		attribute.Int("status", resp.StatusCode),
+		attribute.String("pop", pop),
+		attribute.String("proto", resp.Proto),
+	)
+
+	// verify the body as it streams in, so memory use doesn't grow with size
+	var sink io.Writer = ioutil.Discard
+	v := newVerifier(expected)
+	total := resp.ContentLength
+	if resp.StatusCode == 200 {
+		sink = v
+		total = int64(size)
+	}
+	progress := newProgressReader(resp.Body, start, total, IdleReadTimeout, abort)
+	received, err := io.Copy(sink, progress)
+	progress.Done()
+	phases.BodyDone()
+	recordProgress(progress, reqLabels)
+	phases.Record(reqLabels)
+	phases.AddEvents(span)
+	span.SetAttributes(attribute.Int64("bytes", received))
+	result.Bytes = received
+	l.Infof("%s(%d): protocol: %s, connection reused: %t", t.Name(), size, resp.Proto, phases.Reused())
+	if err != nil {
+		if progress.Stalled() {
+			inc(stalls, reqLabels, ex)
+			result.Outcome = resultlog.Stall
+			return fmt.Errorf("%s(%d): stalled: no data received from gateway for %s after %d bytes. pop: %s, url: %s, request id: %s", t.Name(), size, IdleReadTimeout, received, pop, url, requestID)
+		}
+		inc(errors, remoteLabels, ex)
+		return fmt.Errorf("%s(%d): failed to download content: %w. request id: %s", t.Name(), size, err, requestID)
+	}
 
 	timeToFirstByte := firstByteTime.Sub(start).Seconds()
 	totalTime := time.Since(start).Seconds()
 	downloadTime := time.Since(firstByteTime).Seconds()
-	downloadBytesPerSecond := float64(size) / downloadTime
+	downloadBytesPerSecond := float64(received) / downloadTime
 
-	fetch_latency.With(responseLabels).Set(float64(timeToFirstByte))
-	var labMap map[string]string = *(&responseLabels)
+	fetch_latency.With(reqLabels).Set(float64(timeToFirstByte))
+	taskLabels := withVariant(t, reqLabels)
 
 	// Record results
-	if t.LatencyHist() != nil {
-		log.Infof("Publishing latency histogram %s with labels %v", t.Name(), labMap)
-		t.LatencyHist().With(responseLabels).Observe(float64(timeToFirstByte))
+	observe(probe_ttfb, reqLabels, timeToFirstByte, ex)
+	observe(probe_fetch, reqLabels, totalTime, ex)
+	if LegacyHistograms && t.LatencyHist() != nil {
+		l.Infof("Publishing latency histogram %s with labels %v", t.Name(), taskLabels)
+		t.LatencyHist().With(taskLabels).Observe(float64(timeToFirstByte))
 	}
-	if t.FetchHist() != nil {
-		log.Infof("Publishing fetch histogram %s with labels %v", t.Name(), labMap)
-		t.FetchHist().With(responseLabels).Observe(float64(totalTime))
+	if LegacyHistograms && t.FetchHist() != nil {
+		l.Infof("Publishing fetch histogram %s with labels %v", t.Name(), taskLabels)
+		t.FetchHist().With(taskLabels).Observe(float64(totalTime))
 	}
 
 	if resp.StatusCode != 200 {
-		fails.With(responseLabels).Inc()
+		inc(fails, responseLabels, ex)
+		result.Outcome = resultlog.Fail
 
-		downloadBytesPerSecond = float64(resp.ContentLength) / downloadTime
-		fetch_speed.With(responseLabels).Set(downloadBytesPerSecond)
+		fetch_speed.With(reqLabels).Set(downloadBytesPerSecond)
 
-		return fmt.Errorf("%s(%d): expected response code 200 from gateway, got %d from %s. url: %s", t.Name(), size, resp.StatusCode, pop, url)
+		return fmt.Errorf("%s(%d): expected response code 200 from gateway, got %d from %s. url: %s, request id: %s", t.Name(), size, resp.StatusCode, pop, url, requestID)
 	}
 
-	fetch_speed.With(responseLabels).Set(downloadBytesPerSecond)
-	log.Infof("%s(%d): finished download in %f seconds. speed: %f bytes/sec. pop: %s", t.Name(), totalTime, size, downloadBytesPerSecond, pop)
+	fetch_speed.With(reqLabels).Set(downloadBytesPerSecond)
+	l.Infof("%s(%d): finished download in %f seconds. speed: %f bytes/sec. pop: %s", t.Name(), totalTime, size, downloadBytesPerSecond, pop)
 
 	// compare response with what we sent
-	log.Infof("%s(%d): checking result", t.Name(), size)
-	if !reflect.DeepEqual(expected, respb) {
-		fails.With(responseLabels).Inc()
-		return fmt.Errorf("%s(%d): expected response from gateway to match generated content. pop: %s, url: %s", t.Name(), size, pop, resp.Request.URL)
+	l.Infof("%s(%d): checking result", t.Name(), size)
+	if err := v.Verify(); err != nil {
+		inc(fails, responseLabels, ex)
+		result.Outcome = resultlog.Fail
+		return fmt.Errorf("%s(%d): expected response from gateway to match generated content: %w. pop: %s, url: %s, request id: %s", t.Name(), size, err, pop, resp.Request.URL, requestID)
 	}
 	return nil
 }
+
+func recordProgress(p *progressReader, labels prometheus.Labels) {
+	log.Debugf("download timeline (seconds:bytes): %s", p.Timeline())
+	fetch_stall.With(labels).Set(p.longestStall.Seconds())
+	for i, m := range progressMilestones {
+		if p.milestones[i] < 0 {
+			continue
+		}
+		progressLabels := prometheus.Labels{"progress": strconv.Itoa(int(m * 100))}
+		for k, v := range labels {
+			progressLabels[k] = v
+		}
+		fetch_progress.With(progressLabels).Set(p.milestones[i].Seconds())
+	}
+}
@@ -0,0 +1,88 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestProbeHistogramBuckets(t *testing.T) {
+	h := newProbeHistogram("test_seconds", "", prometheus.ExponentialBuckets(0.1, 2, 4))
+	labels := prometheus.Labels{"test": "t", "pop": "p", "location": "l", "size": "0", "code": "200", "conn": "warm", "proto": "HTTP/1.1"}
+	h.With(labels).Observe(0.3)
+
+	var m dto.Metric
+	if err := h.With(labels).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	hist := m.GetHistogram()
+	if n := len(hist.GetBucket()); n != 4 {
+		t.Errorf("%d classic buckets, expected 4", n)
+	}
+	if hist.Schema == nil || len(hist.GetPositiveSpan()) == 0 {
+		t.Error("expected native buckets too")
+	}
+}
+
+func TestConfigureProbeHistograms(t *testing.T) {
+	summary := newProbeHistogram("configured_summary_seconds", "", prometheus.ExponentialBuckets(0.1, 2, 4))
+	explicit := newProbeHistogram("configured_explicit_seconds", "", prometheus.ExponentialBuckets(0.1, 2, 4))
+	t.Cleanup(func() { ConfigureHistograms(nil, "") })
+	err := ConfigureHistograms([]HistogramConfig{
+		{Histogram: "probe_configured_summary_seconds", Type: "summary"},
+		{Histogram: "probe_configured_explicit_seconds", Type: "explicit", Buckets: []float64{1, 2}},
+	}, "https://ipfs.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := prometheus.Labels{"test": "t", "pop": "p", "location": "l", "size": "0", "code": "200", "conn": "warm", "proto": "HTTP/1.1"}
+	var m dto.Metric
+	if err := summary.With(labels).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.GetSummary().GetQuantile()); n != len(defaultQuantiles) {
+		t.Errorf("%d quantiles, expected %d", n, len(defaultQuantiles))
+	}
+
+	m.Reset()
+	explicit.With(labels).Observe(0.3)
+	if err := explicit.With(labels).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	hist := m.GetHistogram()
+	if n := len(hist.GetBucket()); n != 2 {
+		t.Errorf("%d classic buckets, expected 2", n)
+	}
+	if hist.Schema == nil || len(hist.GetPositiveSpan()) == 0 {
+		t.Error("expected the native buckets to be kept")
+	}
+}
+
+func TestConfigureUnknownHistogram(t *testing.T) {
+	t.Cleanup(func() { ConfigureHistograms(nil, "") })
+	err := ConfigureHistograms([]HistogramConfig{{Test: "known_good", Histogram: "probe_ttfb_seconds", Type: "summary"}}, "")
+	if err == nil {
+		t.Error("expected probe histograms to be rejected under a test")
+	}
+}
+
+func TestMatrixSpread(t *testing.T) {
+	if len(Matrix) != 24 {
+		t.Fatalf("matrix has %d tasks, expected 24", len(Matrix))
+	}
+	schedules := make(map[string]bool)
+	for _, m := range Matrix {
+		s := m.Registration().Schedule
+		if schedules[s] {
+			t.Errorf("more than one matrix task runs at %q", s)
+		}
+		schedules[s] = true
+		for _, a := range All {
+			if a == m {
+				t.Errorf("%s at %q is in All without --matrix", m.Name(), s)
+			}
+		}
+	}
+}
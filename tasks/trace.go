@@ -0,0 +1,158 @@
+package tasks
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// phaseTrace hooks into net/http/httptrace to time each phase of a request,
+// so a slow fetch can be pinned on DNS, TCP, TLS or the gateway itself.
+type phaseTrace struct {
+	mu sync.Mutex
+
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	wroteRequest              time.Time
+	firstByte                 time.Time
+	bodyDone                  time.Time
+	reused                    bool
+
+	onFirstByte func()
+}
+
+func newPhaseTrace(onFirstByte func()) *phaseTrace {
+	return &phaseTrace{onFirstByte: onFirstByte}
+}
+
+func (p *phaseTrace) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { p.mark(&p.dnsStart, false) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { p.mark(&p.dnsDone, true) },
+		// with happy eyeballs there may be several dials in flight. Time from
+		// the first one starting to the last one finishing.
+		ConnectStart:         func(string, string) { p.mark(&p.connectStart, false) },
+		ConnectDone:          func(string, string, error) { p.mark(&p.connectDone, true) },
+		TLSHandshakeStart:    func() { p.mark(&p.tlsStart, false) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.mark(&p.tlsDone, true) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { p.mark(&p.wroteRequest, true) },
+		GotConn:              p.gotConn,
+		GotFirstResponseByte: p.gotFirstResponseByte,
+	}
+}
+
+func (p *phaseTrace) mark(t *time.Time, overwrite bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if overwrite || t.IsZero() {
+		*t = time.Now()
+	}
+}
+
+func (p *phaseTrace) gotConn(info httptrace.GotConnInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reused = info.Reused
+}
+
+func (p *phaseTrace) gotFirstResponseByte() {
+	p.mark(&p.firstByte, false)
+	if p.onFirstByte != nil {
+		p.onFirstByte()
+	}
+}
+
+// BodyDone marks the end of the body transfer.
+func (p *phaseTrace) BodyDone() {
+	p.mark(&p.bodyDone, true)
+}
+
+// Reused reports whether the request went over a pooled connection.
+func (p *phaseTrace) Reused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reused
+}
+
+// Record observes every phase that happened during the request. Phases that
+// were skipped, such as DNS and TLS on a reused connection, are not observed.
+func (p *phaseTrace) Record(labels prometheus.Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	observe := func(h *prometheus.HistogramVec, start, end time.Time) {
+		if start.IsZero() || end.IsZero() {
+			return
+		}
+		h.With(labels).Observe(end.Sub(start).Seconds())
+	}
+	observe(phase_dns, p.dnsStart, p.dnsDone)
+	observe(phase_connect, p.connectStart, p.connectDone)
+	observe(phase_tls, p.tlsStart, p.tlsDone)
+	observe(phase_server, p.wroteRequest, p.firstByte)
+	observe(phase_transfer, p.firstByte, p.bodyDone)
+
+	connLabels := prometheus.Labels{"reused": "false"}
+	if p.reused {
+		connLabels["reused"] = "true"
+	}
+	for k, v := range labels {
+		connLabels[k] = v
+	}
+	connections.With(connLabels).Inc()
+}
+
+// AddEvents adds the start and end of every phase that happened during the
+// request to span.
+func (p *phaseTrace) AddEvents(span trace.Span) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	event := func(name string, at time.Time) {
+		if !at.IsZero() {
+			span.AddEvent(name, trace.WithTimestamp(at))
+		}
+	}
+	event("dns_start", p.dnsStart)
+	event("dns_done", p.dnsDone)
+	event("connect_start", p.connectStart)
+	event("connect_done", p.connectDone)
+	event("tls_start", p.tlsStart)
+	event("tls_done", p.tlsDone)
+	event("wrote_request", p.wroteRequest)
+	event("first_byte", p.firstByte)
+	event("body_done", p.bodyDone)
+}
+
+func newPhaseHistogram(name string, buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      name,
+			Buckets:   buckets,
+		},
+		requestLabels)
+}
+
+var (
+	phase_dns      = newPhaseHistogram("phase_dns_seconds", prometheus.ExponentialBuckets(0.001, 2, 14))     // 1ms-8 seconds
+	phase_connect  = newPhaseHistogram("phase_connect_seconds", prometheus.ExponentialBuckets(0.001, 2, 14)) // 1ms-8 seconds
+	phase_tls      = newPhaseHistogram("phase_tls_seconds", prometheus.ExponentialBuckets(0.001, 2, 14))     // 1ms-8 seconds
+	phase_server   = newPhaseHistogram("phase_server_seconds", prometheus.ExponentialBuckets(0.01, 2, 16))   // 10ms-5 minutes
+	phase_transfer = newPhaseHistogram("phase_transfer_seconds", prometheus.ExponentialBuckets(0.01, 2, 16)) // 10ms-5 minutes
+
+	connections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "gatewaymonitor_task",
+			Subsystem: "common",
+			Name:      "connection_count",
+		},
+		labelNames(requestLabels, "reused"))
+)
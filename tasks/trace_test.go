@@ -0,0 +1,84 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// observed returns how many observations, or counts, c has for the metrics
+// that have all of the labels in match.
+func observed(c prometheus.Collector, match prometheus.Labels) float64 {
+	metrics, _ := collected(c, match)
+	var total float64
+	for _, m := range metrics {
+		switch {
+		case m.Histogram != nil:
+			total += float64(m.Histogram.GetSampleCount())
+		case m.Counter != nil:
+			total += m.Counter.GetValue()
+		}
+	}
+	return total
+}
+
+func TestPhaseTrace(t *testing.T) {
+	body := []byte("Hello World!\r\n")
+	pop := "tls-phases"
+	srv, tlsConfig := newTLSGateway(t, false, pop, body)
+	// go through the resolver, so there is a DNS phase too. The test
+	// certificate is for example.com.
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := "https://localhost:" + u.Port()
+	tlsConfig.ServerName = "example.com"
+
+	check := NewKnownGoodCheck("* * * * *", nil)
+	check.SetConnMode(ConnModeWarm)
+	check.SetTLSConfig(tlsConfig)
+	expected := func() io.Reader { return bytes.NewReader(body) }
+	labels := prometheus.Labels{"test": check.Name(), "pop": pop}
+
+	phases := []struct {
+		name        string
+		h           *prometheus.HistogramVec
+		first, both float64
+	}{
+		{"dns", phase_dns, 1, 1},
+		{"connect", phase_connect, 1, 1},
+		{"tls", phase_tls, 1, 1},
+		{"server", phase_server, 1, 2},
+		{"transfer", phase_transfer, 1, 2},
+	}
+	reused := func(r string) float64 {
+		return observed(connections, prometheus.Labels{"test": check.Name(), "pop": pop, "reused": r})
+	}
+
+	// the first run dials, the second reuses its connection
+	for run := 1; run <= 2; run++ {
+		if err := checkAndRecord(context.Background(), check, &check.prober, gw, gw+"/ipfs/hello", len(body), expected, nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range phases {
+			want := p.first
+			if run == 2 {
+				want = p.both
+			}
+			if got := observed(p.h, labels); got != want {
+				t.Errorf("run %d: %s phase observed %v times in all, expected %v", run, p.name, got, want)
+			}
+		}
+		if got := reused("false"); got != 1 {
+			t.Errorf("run %d: %v new connections, expected 1", run, got)
+		}
+		if got, want := reused("true"), float64(run-1); got != want {
+			t.Errorf("run %d: %v reused connections, expected %v", run, got, want)
+		}
+	}
+}
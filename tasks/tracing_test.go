@@ -0,0 +1,80 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/engine"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/ipfs"
+	"github.com/ipfs-shipyard/gateway-monitor/pkg/tracing"
+)
+
+func TestTaskTrace(t *testing.T) {
+	if _, err := tracing.Setup(context.Background(), tracing.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	otel.SetTracerProvider(tp)
+	defer tp.Shutdown(context.Background())
+
+	// the gateway notes the traceparent of every request
+	node := ipfs.NewMemoryNode()
+	var mu sync.Mutex
+	var traceparents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+		node.Gateway().ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	bench := NewRandomLocalBench("* * * * *", 64*kiB)
+	eng := engine.NewSingle(node, nil, srv.URL)
+	eng.AddTask(bench)
+	eng.AddTask(eng.TerminalTask())
+	for err := range eng.Start(context.Background()) {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exp.GetSpans() {
+		spans[s.Name] = s
+	}
+	root, ok := spans[bench.Name()]
+	if !ok {
+		t.Fatalf("no root span for the run among %v", exp.GetSpans().Snapshots())
+	}
+	if root.Parent.IsValid() {
+		t.Error("the run's span has a parent")
+	}
+	for _, name := range []string{"generate_content", "gateway_request", "cleanup_unpin"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if s.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("%s span isn't a child of the run's span", name)
+		}
+	}
+
+	request := spans["gateway_request"]
+	if len(traceparents) != 1 {
+		t.Fatalf("gateway got %d requests, expected 1", len(traceparents))
+	}
+	got := strings.Split(traceparents[0], "-")
+	if len(got) != 4 || got[1] != root.SpanContext.TraceID().String() || got[2] != request.SpanContext.SpanID().String() {
+		t.Errorf("gateway got traceparent %q, expected the gateway request's span %s in trace %s",
+			traceparents[0], request.SpanContext.SpanID(), root.SpanContext.TraceID())
+	}
+}